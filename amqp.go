@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/xml"
 	"fmt"
 	"math/rand"
 	"sync"
@@ -31,6 +30,7 @@ type AMQPConfig struct {
 	RetryDuration    time.Duration `env:"-"`
 	MaxRetry         string        `env:"MAXRETRY"`
 	MaxRetryDuration time.Duration `env:"-"`
+	Mapping          MappingConfig `env:"GRACC_AMQP_MAPPING_"`
 }
 
 func (c *AMQPConfig) Validate() error {
@@ -46,7 +46,7 @@ func (c *AMQPConfig) Validate() error {
 	if err != nil {
 		return fmt.Errorf("error parsing MaxRetry: %s", err)
 	}
-	return nil
+	return c.Mapping.Validate()
 }
 
 type AMQPOutput struct {
@@ -58,12 +58,8 @@ type AMQPOutput struct {
 }
 
 func InitAMQP(conf AMQPConfig) (*AMQPOutput, error) {
-	var a = &AMQPOutput{
-		Config: conf,
-		URI: conf.Scheme + "://" + conf.User + ":" + conf.Password + "@" +
-			conf.Host + ":" + conf.Port + "/" + conf.Vhost,
-	}
-	if err := a.setup(); err != nil {
+	a, err := dialAMQP(conf)
+	if err != nil {
 		return nil, err
 	}
 	// declare exchange
@@ -94,6 +90,19 @@ func InitAMQP(conf AMQPConfig) (*AMQPOutput, error) {
 	return a, nil
 }
 
+// dialAMQP connects to the AMQP broker, without declaring anything.
+func dialAMQP(conf AMQPConfig) (*AMQPOutput, error) {
+	var a = &AMQPOutput{
+		Config: conf,
+		URI: conf.Scheme + "://" + conf.User + ":" + conf.Password + "@" +
+			conf.Host + ":" + conf.Port + "/" + conf.Vhost,
+	}
+	if err := a.setup(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
 // backoff computes the next backoff duration, using "Decorrelated Jitter" method.
 // https://www.awsarchitectureblog.com/2015/03/backoff.html
 func backoff(last time.Duration, base time.Duration, max time.Duration) time.Duration {
@@ -238,9 +247,9 @@ func (w *AMQPWorker) PublishRecord(rec gracc.Record) error {
 	default:
 	}
 	// publish record
-	pub := w.makePublishing(rec)
-	if pub == nil {
-		return NewAMQPError("error making AMQP publishing from Record")
+	pub, err := w.makePublishing(rec)
+	if err != nil {
+		return NewRecordError(fmt.Sprintf("error making AMQP publishing from %s record %s: %s", rec.Type(), rec.Id(), err))
 	}
 	ll.WithFields(log.Fields{
 		"exchange":   w.Config.Exchange,
@@ -336,7 +345,7 @@ func (w *AMQPWorker) Close() error {
 	return w.Channel.Close()
 }
 
-func (w *AMQPWorker) makePublishing(jur gracc.Record) *amqp.Publishing {
+func (w *AMQPWorker) makePublishing(jur gracc.Record) (*amqp.Publishing, error) {
 	ll := log.WithFields(log.Fields{
 		"where": "AMQPWorker.makePublishing",
 	})
@@ -350,23 +359,42 @@ func (w *AMQPWorker) makePublishing(jur gracc.Record) *amqp.Publishing {
 		pub.ContentType = "text/xml"
 		pub.Body = jur.Raw()
 	case "xml":
-		if j, err := xml.Marshal(jur); err != nil {
-			ll.Error("error converting JobUsageRecord to xml")
+		if j, err := jur.ToXML(""); err != nil {
+			ll.Error("error converting record to xml")
 			ll.Debugf("%v", jur)
-			return nil
+			return nil, err
 		} else {
 			pub.ContentType = "text/xml"
 			pub.Body = j
 		}
 	case "json":
-		if j, err := jur.ToJSON("    "); err != nil {
+		if j, err := w.Config.Mapping.toJSON(jur); err != nil {
 			ll.Error("error converting JobUsageRecord to json")
 			ll.Debugf("%v", jur)
-			return nil
+			return nil, err
+		} else {
+			pub.ContentType = "application/json"
+			pub.Body = j
+		}
+	case "ur-json":
+		if j, err := jur.ToURJSON(w.Config.Mapping.indent()); err != nil {
+			ll.Error("error converting record to UR json")
+			ll.Debugf("%v", jur)
+			return nil, err
 		} else {
 			pub.ContentType = "application/json"
 			pub.Body = j
 		}
+	case "protobuf":
+		if b, err := w.Config.Mapping.toProtobuf(jur); err != nil {
+			ll.Error("error converting record to protobuf")
+			ll.Debugf("%v", jur)
+			return nil, err
+		} else {
+			pub.ContentType = "application/x-protobuf"
+			pub.Type = "gracc.Record"
+			pub.Body = b
+		}
 	}
-	return &pub
+	return &pub, nil
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/opensciencegrid/gracc-collector/gracc"
+	"github.com/streadway/amqp"
+)
+
+type AMQPInputConfig struct {
+	Enable   bool   `env:"ENABLE"`
+	Host     string `env:"HOST"`
+	Port     string `env:"PORT"`
+	Scheme   string `env:"SCHEME"`
+	Vhost    string `env:"VHOST"`
+	User     string `env:"USER"`
+	Password string `env:"PASSWORD"`
+	Queue    string `env:"QUEUE"`
+	Prefetch int    `env:"PREFETCH"`
+	Retry    string `env:"RETRY"`
+	MaxRetry string `env:"MAXRETRY"`
+}
+
+func (c *AMQPInputConfig) Validate() error {
+	if !c.Enable {
+		return nil
+	}
+	if c.Queue == "" {
+		return fmt.Errorf("no AMQPInput queue configured")
+	}
+	if c.Prefetch <= 0 {
+		c.Prefetch = 100
+	}
+	if c.Retry == "" {
+		c.Retry = "1s"
+	}
+	if c.MaxRetry == "" {
+		c.MaxRetry = "1h"
+	}
+	conf := c.connConfig()
+	return conf.Validate()
+}
+
+// connConfig returns an AMQPConfig with the connection settings.
+func (c *AMQPInputConfig) connConfig() AMQPConfig {
+	return AMQPConfig{
+		Host:     c.Host,
+		Port:     c.Port,
+		Scheme:   c.Scheme,
+		Vhost:    c.Vhost,
+		User:     c.User,
+		Password: c.Password,
+		Retry:    c.Retry,
+		MaxRetry: c.MaxRetry,
+	}
+}
+
+// AMQPInput consumes raw record XML from an AMQP queue, one record
+// per message, and publishes the records to the configured outputs.
+//
+// Each message is sent as its own bundle, and acked only once the record has
+// been sent to all outputs. Messages that can not be parsed, or whose record
+// is rejected (e.g. by validation), are rejected without requeueing, so they
+// will be dead-lettered if the queue is so configured. If sending fails
+// because an output is unavailable the message is requeued.
+type AMQPInput struct {
+	Config     AMQPInputConfig
+	connection *AMQPOutput
+	collector  *GraccCollector
+	done       chan struct{}
+}
+
+func InitAMQPInput(conf AMQPInputConfig, g *GraccCollector) (*AMQPInput, error) {
+	log.WithField("queue", conf.Queue).Info("initializing AMQP input")
+	cc := conf.connConfig()
+	if err := cc.Validate(); err != nil {
+		return nil, err
+	}
+	c, err := dialAMQP(cc)
+	if err != nil {
+		return nil, err
+	}
+	return &AMQPInput{
+		Config:     conf,
+		connection: c,
+		collector:  g,
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// Run consumes from the queue, re-opening the channel as needed,
+// until Stop is called.
+func (i *AMQPInput) Run() {
+	retry := i.connection.Config.RetryDuration
+	sleep := retry
+	for {
+		handled, err := i.consume()
+		if err == nil {
+			return
+		}
+		if handled > 0 {
+			sleep = retry
+		}
+		log.WithFields(log.Fields{
+			"queue": i.Config.Queue,
+			"error": err,
+			"retry": sleep.String(),
+		}).Error("AMQPInput: error consuming from queue")
+		select {
+		case <-i.done:
+			return
+		case <-time.After(sleep):
+		}
+		sleep = backoff(sleep, retry, i.connection.Config.MaxRetryDuration)
+	}
+}
+
+// Stop stops consuming from the queue. Unacked messages are requeued.
+func (i *AMQPInput) Stop() {
+	close(i.done)
+}
+
+// consume opens a channel and handles deliveries until the channel is closed,
+// an error occurs, or the input is stopped, returning the number of
+// deliveries handled. The error is nil only if the input was stopped.
+func (i *AMQPInput) consume() (int, error) {
+	handled := 0
+	ch, err := i.connection.OpenChannel()
+	if err != nil {
+		return handled, err
+	}
+	defer ch.Close()
+	if err := ch.Qos(i.Config.Prefetch, 0, false); err != nil {
+		return handled, err
+	}
+	deliveries, err := ch.Consume(i.Config.Queue, "", false, false, false, false, nil)
+	if err != nil {
+		return handled, err
+	}
+	log.WithField("queue", i.Config.Queue).Info("AMQPInput: consuming")
+	for {
+		select {
+		case <-i.done:
+			return handled, nil
+		case d, ok := <-deliveries:
+			if !ok {
+				return handled, NewAMQPError("channel closed")
+			}
+			if err := i.handle(d); err != nil {
+				return handled, err
+			}
+			handled++
+		}
+	}
+}
+
+// handle parses and publishes a delivery, then acks or nacks it. The delivery
+// is only requeued if sending to the outputs failed, and that error is
+// returned.
+func (i *AMQPInput) handle(d amqp.Delivery) error {
+	ll := log.WithFields(log.Fields{
+		"queue": i.Config.Queue,
+		"tag":   d.DeliveryTag,
+	})
+	rec, err := i.collector.parseRecordXML(d.Body)
+	if err != nil {
+		i.collector.Events <- GOT_RECORD
+		i.collector.Events <- RECORD_ERROR
+		ll.WithField("error", err).Warning("AMQPInput: error parsing record; rejecting")
+		return d.Nack(false, false)
+	}
+	outcomes, err := i.collector.sendRecords([]gracc.Record{rec}, nil)
+	switch err.(type) {
+	case nil:
+		if outcomes[0] == outcomeFailed {
+			// the record could not be encoded for an output
+			ll.Warning("AMQPInput: record was not sent; rejecting")
+			return d.Nack(false, false)
+		}
+		ll.Debug("AMQPInput: sent record")
+		return d.Ack(false)
+	case RecordError, RequestError:
+		// the record can never be sent (e.g. rejected by validation), so
+		// reject without requeueing, to be dead-lettered if so configured.
+		ll.WithField("error", err).Warning("AMQPInput: error sending record; rejecting")
+		return d.Nack(false, false)
+	default:
+		// an output is unavailable; requeue to try again later
+		d.Nack(false, true)
+		return err
+	}
+}
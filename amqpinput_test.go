@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestAMQPInput(t *testing.T) {
+	conf := AMQPInputConfig{
+		Enable:   true,
+		Host:     config.AMQP.Host,
+		Port:     config.AMQP.Port,
+		Scheme:   config.AMQP.Scheme,
+		User:     config.AMQP.User,
+		Password: config.AMQP.Password,
+		Queue:    "gracc.test.input",
+		Prefetch: 10,
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := ioutil.ReadFile("gracc/test_data/JobUsageRecord01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := consumer.OpenChannel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Close()
+	// rejected messages are dead-lettered to the dead queue, and published
+	// records are also routed to the output queue
+	dead := conf.Queue + ".dead"
+	output := conf.Queue + ".output"
+	if _, err := ch.QueueDeclare(dead, false, true, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.QueueDeclare(output, false, true, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.QueueBind(output, "#", config.AMQP.Exchange, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.QueueDeclare(conf.Queue, false, true, false, false, amqp.Table{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": dead,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range [][]byte{rec, []byte("<NotARecord/>"), rec} {
+		if err := ch.Publish("", conf.Queue, false, false, amqp.Publishing{
+			ContentType: "text/xml",
+			Body:        body,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in, err := InitAMQPInput(conf, collector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		in.Run()
+		close(stopped)
+	}()
+	defer func() {
+		in.Stop()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Error("AMQPInput.Run did not return after Stop")
+		}
+	}()
+
+	// wait for the bad message to be rejected and the good records published
+	for n := 0; ; n++ {
+		d, err := ch.QueueInspect(dead)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, err := ch.QueueInspect(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Messages == 1 && o.Messages == 2 {
+			break
+		}
+		if n == 50 {
+			t.Fatalf("dead queue has %d messages, output queue has %d; expected 1 and 2", d.Messages, o.Messages)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if q, err := ch.QueueInspect(conf.Queue); err != nil {
+		t.Fatal(err)
+	} else if q.Messages != 0 {
+		t.Errorf("input queue has %d messages, expected 0", q.Messages)
+	}
+	m, ok, err := ch.Get(dead, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || string(m.Body) != "<NotARecord/>" {
+		t.Errorf("expected bad record to be dead-lettered, got %q", m.Body)
+	}
+
+	for n := 0; n < 2; n++ {
+		m, ok, err := ch.Get(output, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("expected 2 published records, got %d", n)
+		}
+		var r map[string]interface{}
+		if err := json.Unmarshal(m.Body, &r); err != nil {
+			t.Fatal(err)
+		}
+		if r["type"] != "JobUsageRecord" {
+			t.Errorf("published record has type %v, expected JobUsageRecord", r["type"])
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/opensciencegrid/gracc-collector/gracc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BenchmarkConfig configures the normalization of JobUsageRecord usage by
+// CPU benchmark, from the JSON benchmark File. The file is checked for
+// changes every Interval.
+type BenchmarkConfig struct {
+	File             string        `env:"FILE"`
+	Interval         string        `env:"INTERVAL"`
+	IntervalDuration time.Duration `env:"-"`
+}
+
+func (c *BenchmarkConfig) Validate() error {
+	return parseInterval("Benchmark", &c.Interval, &c.IntervalDuration)
+}
+
+// benchmarkEntry is the benchmark Value (per core), e.g. of Benchmark "HS06",
+// of records whose Site (or else SiteName), ProbeName and MachineName match
+// Site, Probe and Machine. These are shell patterns (see path.Match), and
+// empty patterns match anything.
+type benchmarkEntry struct {
+	Site      string
+	Probe     string
+	Machine   string
+	Benchmark string
+	Value     float64
+}
+
+func (e *benchmarkEntry) matches(r map[string]interface{}) bool {
+	for _, m := range []struct{ p, s string }{
+		{e.Site, firstString(r, "Site", "SiteName")},
+		{e.Probe, firstString(r, "ProbeName")},
+		{e.Machine, firstString(r, "MachineName")},
+	} {
+		if m.p == "" {
+			continue
+		}
+		if ok, _ := path.Match(m.p, m.s); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// loadBenchmarks reads the benchmark entries from file.
+func loadBenchmarks(file string) ([]benchmarkEntry, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entries []benchmarkEntry
+	if err := json.Unmarshal(buf, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing benchmark file %s: %s", file, err)
+	}
+	for i, e := range entries {
+		for _, p := range []string{e.Site, e.Probe, e.Machine} {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("benchmark file %s entry %d: bad pattern %q", file, i+1, p)
+			}
+		}
+		if e.Benchmark == "" || e.Value <= 0 {
+			return nil, fmt.Errorf("benchmark file %s entry %d: missing benchmark or value", file, i+1)
+		}
+	}
+	return entries, nil
+}
+
+// benchmarks is the stage that adds usage normalized by CPU benchmark to
+// JobUsageRecords.
+type benchmarks struct {
+	watchedFile
+	entries []benchmarkEntry
+	em      sync.RWMutex
+	mm      sync.Mutex
+	missing map[string]uint64 // by probe
+
+	MissingDesc *prometheus.Desc
+}
+
+// newBenchmarks loads the benchmark file, and starts watching it for changes.
+func newBenchmarks(conf BenchmarkConfig) (*benchmarks, error) {
+	var b = &benchmarks{
+		missing: make(map[string]uint64),
+		MissingDesc: prometheus.NewDesc(
+			"gracc_benchmark_missing_total",
+			"Number of job records with no benchmark in the benchmark file, by probe.",
+			[]string{"probe"},
+			nil,
+		),
+	}
+	b.watchedFile = watchedFile{file: conf.File, load: b.load}
+	if err := b.reload(); err != nil {
+		return nil, err
+	}
+	b.watch(conf.IntervalDuration)
+	return b, nil
+}
+
+func (b *benchmarks) load(file string) error {
+	entries, err := loadBenchmarks(file)
+	if err != nil {
+		return err
+	}
+	b.em.Lock()
+	b.entries = entries
+	b.em.Unlock()
+	return nil
+}
+
+// lookup returns the entry for raw record r, or nil if there is none.
+func (b *benchmarks) lookup(r map[string]interface{}) *benchmarkEntry {
+	b.em.RLock()
+	defer b.em.RUnlock()
+	for i := range b.entries {
+		if b.entries[i].matches(r) {
+			e := b.entries[i]
+			return &e
+		}
+	}
+	return nil
+}
+
+// process adds the benchmark and usage normalized by it to JobUsageRecords:
+// NormalizedWallDuration is the WallDuration of all cores times the benchmark
+// Value, and NormalizedCpuDuration the CpuDuration times the Value. CoreHours
+// is also set. Records with no benchmark have "_benchmarkMissing" set.
+func (b *benchmarks) process(rec gracc.Record, r map[string]interface{}) (bool, error) {
+	if r["type"] != "JobUsageRecord" {
+		return true, nil
+	}
+	wall, _ := numberValue(r["WallDuration"])
+	cpu, _ := numberValue(r["CpuDuration"])
+	cores, _ := gracc.CoreCount(r)
+	r["CoreHours"] = gracc.CoreHours(r)
+	e := b.lookup(r)
+	if e == nil {
+		r["_benchmarkMissing"] = true
+		probe, _ := r["ProbeName"].(string)
+		b.mm.Lock()
+		b.missing[probe]++
+		b.mm.Unlock()
+		return true, nil
+	}
+	r["Benchmark"] = e.Benchmark
+	r["BenchmarkValue"] = e.Value
+	r["NormalizedWallDuration"] = wall * cores * e.Value
+	r["NormalizedCpuDuration"] = cpu * e.Value
+	return true, nil
+}
+
+func (b *benchmarks) Describe(ch chan<- *prometheus.Desc) {
+	ch <- b.MissingDesc
+}
+
+func (b *benchmarks) Collect(ch chan<- prometheus.Metric) {
+	b.mm.Lock()
+	defer b.mm.Unlock()
+	for probe, n := range b.missing {
+		ch <- prometheus.MustNewConstMetric(
+			b.MissingDesc,
+			prometheus.CounterValue,
+			float64(n),
+			probe,
+		)
+	}
+}
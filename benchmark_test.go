@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBenchmarks(t *testing.T) {
+	dir := writeTemp(t, "gracc-benchmark", map[string]string{"benchmarks.json": `[
+		{"site": "UCSDT2-*", "machine": "osg-gw-7.*", "benchmark": "HS06", "value": 12.5},
+		{"site": "TAMU", "benchmark": "HS23", "value": 20}
+	]`})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "benchmarks.json")
+	conf := BenchmarkConfig{File: file}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := newBenchmarks(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.close()
+
+	for _, tc := range []struct {
+		file   string
+		site   string                 // canonical Site, if set
+		expect map[string]interface{} // expected values; nil means absent
+	}{
+		{"gracc/test_data/JobUsageRecord06.xml", "", map[string]interface{}{"Benchmark": "HS06", "BenchmarkValue": 12.5,
+			"CoreHours": 617 / 3600.0, "NormalizedWallDuration": 617 * 12.5, "NormalizedCpuDuration": 18 * 12.5,
+			"_benchmarkMissing": nil}},
+		// Site is matched before SiteName
+		{"gracc/test_data/JobUsageRecord06.xml", "UCSD", map[string]interface{}{"Benchmark": nil,
+			"_benchmarkMissing": true}},
+		{"gracc/test_data/JobUsageRecord04.xml", "TAMU", map[string]interface{}{"Benchmark": "HS23", "BenchmarkValue": 20.0,
+			"NormalizedWallDuration": 21 * 20.0}},
+		{"gracc/test_data/JobUsageRecord04.xml", "", map[string]interface{}{"Benchmark": nil}},
+		{"gracc/test_data/JobUsageRecord02.xml", "", map[string]interface{}{"Benchmark": nil, "NormalizedWallDuration": nil,
+			"CoreHours": 4 / 3600.0, "_benchmarkMissing": true}},
+		{"gracc/test_data/StorageElementRecord01.xml", "", map[string]interface{}{"CoreHours": nil, "_benchmarkMissing": nil}},
+	} {
+		rec, r := loadRecord(t, tc.file)
+		if tc.site != "" {
+			r["Site"] = tc.site
+		}
+		if keep, err := b.process(rec, r); !keep || err != nil {
+			t.Fatalf("%s: record rejected: %v", tc.file, err)
+		}
+		checkFields(t, tc.file+" "+tc.site, r, tc.expect)
+	}
+	if n := b.missing[""]; n != 1 || b.missing["condor:osg-gw-7.t2.ucsd.edu"] != 1 || b.missing["slurm:ce01.brazos.tamu.edu"] != 1 {
+		t.Errorf("unexpected records with no benchmark %v", b.missing)
+	}
+
+	for _, bad := range []string{
+		`[{"site": "[", "benchmark": "HS06", "value": 10}]`,
+		`[{"site": "X", "value": 10}]`,
+		`[{"site": "X", "benchmark": "HS06"}]`,
+		`{"site": "X"}`,
+	} {
+		if err := ioutil.WriteFile(file, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadBenchmarks(file); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
@@ -37,8 +39,15 @@ type GraccCollector struct {
 	Config      *CollectorConfig
 	AMQPOutput  *AMQPOutput
 	KafkaOutput *KafkaOutput
+	SpoolInput  *SpoolInput
+	AMQPInput   *AMQPInput
 	Stats       CollectorStats
+	Probes      *ProbeRegistry
 	m           sync.Mutex
+	// durationWarnings counts invalid durations by field.
+	durationWarnings map[string]uint64
+	// stages process records before they are sent.
+	stages []stage
 
 	Events chan Event
 
@@ -46,12 +55,59 @@ type GraccCollector struct {
 	RecordErrorCountDesc  *prometheus.Desc
 	RequestCountDesc      *prometheus.Desc
 	RequestErrorCountDesc *prometheus.Desc
+	DurationWarningDesc   *prometheus.Desc
 }
 
 // NewCollector initializes and returns a new Gracc collector.
 func NewCollector(conf *CollectorConfig) (*GraccCollector, error) {
 	var g GraccCollector
 	g.Config = conf
+	g.Probes = NewProbeRegistry()
+	g.durationWarnings = make(map[string]uint64)
+
+	if err := gracc.SetOptions(conf.Records.jsonOptions()); err != nil {
+		return nil, err
+	}
+
+	if conf.TimeCheck.enabled() {
+		g.stages = append(g.stages, newTimeChecker(conf.TimeCheck))
+	}
+	if conf.Topology.File != "" {
+		if t, err := newTopology(conf.Topology); err != nil {
+			return nil, err
+		} else {
+			g.stages = append(g.stages, t)
+		}
+	}
+	if conf.VOMap.enabled() {
+		if v, err := newVOMap(conf.VOMap); err != nil {
+			return nil, err
+		} else {
+			g.stages = append(g.stages, v)
+		}
+	}
+	if conf.Benchmark.File != "" {
+		if b, err := newBenchmarks(conf.Benchmark); err != nil {
+			return nil, err
+		} else {
+			g.stages = append(g.stages, b)
+		}
+	}
+	if conf.RateCard.File != "" {
+		if c, err := newRateCards(conf.RateCard); err != nil {
+			return nil, err
+		} else {
+			g.stages = append(g.stages, c)
+		}
+	}
+	if len(conf.Validation.Rules) > 0 {
+		g.stages = append(g.stages, newValidator(conf.Validation))
+	}
+	if len(g.stages) > 0 {
+		if err := checkStageFormats(conf); err != nil {
+			return nil, err
+		}
+	}
 
 	g.Events = make(chan Event)
 	go g.LogEvents()
@@ -72,6 +128,24 @@ func NewCollector(conf *CollectorConfig) (*GraccCollector, error) {
 		}
 	}
 
+	if g.Config.Spool.Enable {
+		if i, err := InitSpool(conf.Spool, &g); err != nil {
+			return nil, err
+		} else {
+			g.SpoolInput = i
+			go i.Run()
+		}
+	}
+
+	if g.Config.AMQPInput.Enable {
+		if i, err := InitAMQPInput(conf.AMQPInput, &g); err != nil {
+			return nil, err
+		} else {
+			g.AMQPInput = i
+			go i.Run()
+		}
+	}
+
 	g.RecordCountDesc = prometheus.NewDesc(
 		"gracc_records_total",
 		"Number of records processed.",
@@ -96,6 +170,12 @@ func NewCollector(conf *CollectorConfig) (*GraccCollector, error) {
 		nil,
 		nil,
 	)
+	g.DurationWarningDesc = prometheus.NewDesc(
+		"gracc_duration_warnings_total",
+		"Number of invalid durations in records, by field.",
+		[]string{"field"},
+		nil,
+	)
 
 	return &g, nil
 }
@@ -133,6 +213,12 @@ func (g *GraccCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- g.RecordErrorCountDesc
 	ch <- g.RequestCountDesc
 	ch <- g.RequestErrorCountDesc
+	ch <- g.DurationWarningDesc
+	for _, s := range g.stages {
+		if c, ok := s.(prometheus.Collector); ok {
+			c.Describe(ch)
+		}
+	}
 }
 
 func (g *GraccCollector) Collect(ch chan<- prometheus.Metric) {
@@ -157,7 +243,20 @@ func (g *GraccCollector) Collect(ch chan<- prometheus.Metric) {
 		prometheus.CounterValue,
 		float64(g.Stats.RequestErrors),
 	)
+	for field, n := range g.durationWarnings {
+		ch <- prometheus.MustNewConstMetric(
+			g.DurationWarningDesc,
+			prometheus.CounterValue,
+			float64(n),
+			field,
+		)
+	}
 	g.m.Unlock()
+	for _, s := range g.stages {
+		if c, ok := s.(prometheus.Collector); ok {
+			c.Collect(ch)
+		}
+	}
 }
 
 // Request is a wrapper struct for passing around an HTTP request
@@ -167,10 +266,14 @@ type Request struct {
 	r     *http.Request
 	log   *log.Entry
 	start time.Time
+	// raw is the request body, if the records were sent directly
+	// in the body rather than in a form.
+	raw []byte
 }
 
-func (g *GraccCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	g.Events <- GOT_REQUEST
+// newRequest wraps an HTTP request, setting up a logger with the
+// request metadata.
+func newRequest(w http.ResponseWriter, r *http.Request) *Request {
 	var remoteAddr string
 
 	// Check for forwarded headers
@@ -182,7 +285,7 @@ func (g *GraccCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		remoteAddr = r.RemoteAddr
 	}
 
-	req := &Request{
+	return &Request{
 		w: w,
 		r: r,
 		log: log.WithFields(log.Fields{
@@ -193,14 +296,58 @@ func (g *GraccCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}),
 		start: time.Now(),
 	}
-	r.ParseForm()
-	if err := g.checkRequiredKeys(req, []string{"command"}); err != nil {
+}
+
+// payload returns the records sent with the request, either in the form
+// field "arg1" or as the raw request body.
+func (req *Request) payload() []byte {
+	if req.raw != nil {
+		return req.raw
+	}
+	return []byte(req.r.FormValue("arg1"))
+}
+
+// readBody reads the request body and parses the form. If the body is not a
+// form, but looks like records (e.g. from "curl --data @record.xml"),
+// it is kept as the raw payload.
+func (g *GraccCollector) readBody(req *Request) error {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(req.w, req.r.Body, g.Config.MaxRequestSize))
+	if err != nil {
+		return NewRequestError(fmt.Sprintf("error reading request: %s", err))
+	}
+	ct := req.r.Header.Get("Content-Type")
+	if strings.Contains(ct, "xml") {
+		req.raw = body
+		req.r.ParseForm()
+		return nil
+	}
+	req.r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.r.ParseForm()
+	if req.r.PostFormValue("command") == "" && req.r.PostFormValue("arg1") == "" {
+		trimmed := bytes.TrimSpace(body)
+		if bytes.HasPrefix(trimmed, []byte("<")) || bytes.HasPrefix(trimmed, []byte("replication|")) {
+			req.raw = body
+		}
+	}
+	return nil
+}
+
+func (g *GraccCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.Events <- GOT_REQUEST
+	req := newRequest(w, r)
+	if err := g.readBody(req); err != nil {
 		g.Events <- REQUEST_ERROR
 		g.handleError(req, err)
 		return
 	}
 	command := r.FormValue("command")
+	if command == "" && req.raw != nil {
+		command = "update"
+	}
 	switch command {
+	case "":
+		g.Events <- REQUEST_ERROR
+		g.handleError(req, NewRequestError("missing key \"command\""))
 	case "update":
 		g.handleUpdate(req)
 	case "multiupdate":
@@ -223,8 +370,10 @@ func (g *GraccCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 //     tarfiles: number of outstanding tar files
 //     maxpendingfiles: 'current' number of files in a new tar file (i.e. an estimate of the number of individual records per tar file).
 //     backlog: estimated amount of data to be processed by the probe
+//
+// The payload type is detected as with handleUpdate.
 func (g *GraccCollector) handleMultiUpdate(req *Request) {
-	if err := g.checkRequiredKeys(req, []string{"arg1", "from"}); err != nil {
+	if err := g.checkPayloadKeys(req); err != nil {
 		g.Events <- REQUEST_ERROR
 		g.handleError(req, err)
 		return
@@ -232,11 +381,11 @@ func (g *GraccCollector) handleMultiUpdate(req *Request) {
 	updateLogger := log.WithFields(log.Fields{
 		"from": req.r.FormValue("from"),
 	})
-	var bun gracc.RecordBundle
-	if err := xml.Unmarshal([]byte(req.r.FormValue("arg1")), &bun); err != nil {
+	bun, err := g.processRecordFile(req.payload())
+	if err != nil {
 		g.Events <- REQUEST_ERROR
-		updateLogger.WithField("error", err).Error("error unmarshalling xml")
-		g.handleError(req, NewRequestError("error unmarshalling xml"))
+		updateLogger.WithField("error", err).Error("error processing records")
+		g.handleError(req, err)
 		return
 	}
 	updateLogger.WithFields(log.Fields{
@@ -244,9 +393,18 @@ func (g *GraccCollector) handleMultiUpdate(req *Request) {
 		"JobUsageRecord":       len(bun.JobUsageRecords),
 		"StorageElement":       len(bun.StorageElements),
 		"StorageElementRecord": len(bun.StorageElementRecords),
+		"ComputeElement":       len(bun.ComputeElements),
+		"ComputeElementRecord": len(bun.ComputeElementRecords),
+		"Subcluster":           len(bun.Subclusters),
+		"MetricRecord":         len(bun.MetricRecords),
+		"ProbeDetails":         len(bun.ProbeDetails),
+		"ComputeRecord":        len(bun.ComputeRecords),
+		"StorageRecord":        len(bun.StorageRecords),
+		"EMIComputeRecord":     len(bun.EMIComputeRecords),
+		"EMIStorageRecord":     len(bun.EMIStorageRecords),
 		"Other":                len(bun.OtherRecords),
 	}).Debug("processed XML record bundle")
-	if err := g.sendBundle(&bun); err != nil {
+	if err := g.sendBundle(bun); err != nil {
 		g.Events <- REQUEST_ERROR
 		updateLogger.WithField("error", err).Error("error sending update")
 		g.handleError(req, err)
@@ -257,8 +415,12 @@ func (g *GraccCollector) handleMultiUpdate(req *Request) {
 }
 
 // handleUpdate handles the typical request from a Gratia collector.
+//
+// The payload may be a replication bundle, an XML record bundle (RecordEnvelope),
+// or a single record, sent either in form field "arg1" or as the request body.
+// If "bundlesize" is given, the number of records must match.
 func (g *GraccCollector) handleUpdate(req *Request) {
-	if err := g.checkRequiredKeys(req, []string{"arg1", "from"}); err != nil {
+	if err := g.checkPayloadKeys(req); err != nil {
 		g.Events <- REQUEST_ERROR
 		g.handleError(req, err)
 		return
@@ -266,30 +428,28 @@ func (g *GraccCollector) handleUpdate(req *Request) {
 	updateLogger := log.WithFields(log.Fields{
 		"from": req.r.FormValue("from"),
 	})
-	if req.r.FormValue("arg1") == "xxx" {
+	if req.raw == nil && req.r.FormValue("arg1") == "xxx" {
 		updateLogger.Info("received ping")
 		g.handleSuccess(req)
 		return
 	}
-	if err := g.checkRequiredKeys(req, []string{"bundlesize"}); err != nil {
-		g.Events <- REQUEST_ERROR
-		g.handleError(req, err)
-		return
-	}
-	bundlesize, err := strconv.Atoi(req.r.FormValue("bundlesize"))
-	if err != nil {
-		g.Events <- REQUEST_ERROR
-		g.handleError(req, NewRequestError("error interpreting bundlesize"))
-		return
+	bundlesize := -1
+	if bs := req.r.FormValue("bundlesize"); bs != "" {
+		var err error
+		if bundlesize, err = strconv.Atoi(bs); err != nil {
+			g.Events <- REQUEST_ERROR
+			g.handleError(req, NewRequestError("error interpreting bundlesize"))
+			return
+		}
 	}
-	bun, err := g.processBundle(req.r.FormValue("arg1"))
+	bun, err := g.processRecordFile(req.payload())
 	if err != nil {
 		g.Events <- REQUEST_ERROR
 		updateLogger.WithField("error", err).Error("error processing bundle")
 		g.handleError(req, err)
 		return
 	}
-	if n := bun.RecordCount(); n != bundlesize {
+	if n := bun.RecordCount(); bundlesize >= 0 && n != bundlesize {
 		g.Events <- REQUEST_ERROR
 		g.handleError(req, NewRequestError(fmt.Sprintf("number of records in bundle (%d) different than expected (%d)", n, bundlesize)))
 		return
@@ -299,10 +459,19 @@ func (g *GraccCollector) handleUpdate(req *Request) {
 		g.handleError(req, err)
 		return
 	}
-	updateLogger.WithField("bundlesize", req.r.FormValue("bundlesize")).Info("received update")
+	updateLogger.WithField("bundlesize", bun.RecordCount()).Info("received update")
 	g.handleSuccess(req)
 }
 
+// checkPayloadKeys checks that the form keys required with an update are
+// present. Raw payloads don't require any.
+func (g *GraccCollector) checkPayloadKeys(req *Request) error {
+	if req.raw != nil {
+		return nil
+	}
+	return g.checkRequiredKeys(req, []string{"arg1", "from"})
+}
+
 func (g *GraccCollector) checkRequiredKeys(req *Request, keys []string) error {
 	for _, k := range keys {
 		if req.r.FormValue(k) == "" {
@@ -333,7 +502,7 @@ ScannerLoop:
 					break ScannerLoop
 				}
 			}
-			rec, err := gracc.ParseRecordXML([]byte(parts["rec"]))
+			rec, err := g.parseRecordXML([]byte(parts["rec"]))
 			if err != nil {
 				log.WithFields(log.Fields{
 					"error": err,
@@ -353,58 +522,234 @@ ScannerLoop:
 	return &bun, nil
 }
 
+// processRecordFile parses the contents of a record file, as would be found
+// in a probe outbox, which may contain a replication bundle, an XML record
+// bundle (RecordEnvelope), or a single record.
+func (g *GraccCollector) processRecordFile(buf []byte) (*gracc.RecordBundle, error) {
+	buf = bytes.TrimSpace(buf)
+	if bytes.HasPrefix(buf, []byte("replication|")) {
+		return g.processBundle(string(buf))
+	}
+	var rec gracc.XMLRecord
+	if err := xml.Unmarshal(buf, &rec); err != nil {
+		return nil, NewRecordError(fmt.Sprintf("error unmarshalling xml: %s", err))
+	}
+	var bun gracc.RecordBundle
+	if rec.XMLName.Local == "RecordEnvelope" {
+		if err := xml.Unmarshal(buf, &bun); err != nil {
+			return nil, NewRecordError(fmt.Sprintf("error unmarshalling xml: %s", err))
+		}
+		if g.Config.Records.PassThrough {
+			bun.PassThrough()
+		}
+		if g.Config.Records.Durations == gracc.DurationsStrict {
+			for rec := range bun.Records() {
+				if errs := gracc.DurationErrors(rec); len(errs) > 0 {
+					return nil, NewRecordError(fmt.Sprintf("error processing %s record %s: %s", rec.Type(), rec.Id(), errs[0]))
+				}
+			}
+		}
+		return &bun, nil
+	}
+	r, err := g.parseRecordXML(buf)
+	if err != nil {
+		return nil, NewRecordError(fmt.Sprintf("error processing %s record: %s", rec.XMLName.Local, err))
+	}
+	bun.AddRecord(r)
+	return &bun, nil
+}
+
+// Outcomes of sending a record, as returned by sendRecords.
+type sendOutcome int
+
+const (
+	// outcomeFailed records were not sent, as they were not recognized or
+	// could not be sent.
+	outcomeFailed sendOutcome = iota
+	// outcomePublished records were sent to all outputs.
+	outcomePublished
+	// outcomeDenied records were not sent, as their type is denied.
+	outcomeDenied
+	// outcomeDropped records were not sent, as a stage dropped them.
+	outcomeDropped
+)
+
 // sendBundle publishes the records in RecordBundle bun to output.
 func (g *GraccCollector) sendBundle(bun *gracc.RecordBundle) error {
-	for _, r := range bun.OtherRecords {
-		g.Events <- GOT_RECORD
-		g.Events <- RECORD_ERROR
+	var recs []gracc.Record
+	for rec := range bun.Records() {
+		recs = append(recs, rec)
+	}
+	_, err := g.sendRecords(recs, bun.OtherRecords)
+	return err
+}
+
+// sendRecords publishes recs to output, returning the outcome of each of recs
+// followed by that of each of the unrecognized records other, which are never
+// sent. Records that can not be encoded for an output fail without an error.
+// If an error is returned, the other records that were not published failed
+// because of it.
+func (g *GraccCollector) sendRecords(recs []gracc.Record, other []gracc.XMLRecord) ([]sendOutcome, error) {
+	outcomes := make([]sendOutcome, len(recs)+len(other))
+	defer g.countOutcomes(outcomes)
+
+	for i, r := range other {
+		if !g.Config.Records.Accept(r.XMLName.Local) {
+			log.WithField("type", r.XMLName.Local).Debug("dropping record of denied type")
+			outcomes[len(recs)+i] = outcomeDenied
+			continue
+		}
 		log.WithField("type", r.XMLName).Warning("bundle contains unrecognized record type; ignoring!")
 	}
 
-	if g.Config.AMQP.Enable {
-		// setup AMQP channel
-		w, err := g.AMQPOutput.NewWorker(bun.RecordCount())
-		if err != nil {
-			log.Error(err)
-			return err
+	var send []int
+	for i, rec := range recs {
+		if pd, ok := rec.(*gracc.ProbeDetails); ok {
+			g.Probes.Update(pd)
 		}
-		defer w.Close()
-
-		npub := 0
-		for rec := range bun.Records() {
-			g.Events <- GOT_RECORD
-			npub += 1
-			if err := w.PublishRecord(rec); err != nil {
-				g.Events <- RECORD_ERROR
-				npub -= 1
-				return err
-			}
+		if !g.Config.Records.Accept(rec.Type()) {
+			log.WithFields(log.Fields{
+				"type": rec.Type(),
+				"id":   rec.Id(),
+			}).Debug("dropping record of denied type")
+			outcomes[i] = outcomeDenied
+			continue
+		}
+		send = append(send, i)
+		g.countDurationWarnings(rec)
+	}
+
+	processed, err := g.processRecords(recs, send)
+	if err != nil {
+		return outcomes, err
+	}
+	var pending []int
+	for _, i := range send {
+		if processed[i] == nil {
+			outcomes[i] = outcomeDropped
+		} else {
+			pending = append(pending, i)
+		}
+	}
+
+	// records confirmed by AMQP are still sent to Kafka if AMQP fails, so
+	// that they are published to all outputs.
+	if g.Config.AMQP.Enable && len(pending) > 0 {
+		pending, err = g.publishAMQP(processed, pending)
+	}
+	if g.Config.Kafka.Enable && len(pending) > 0 {
+		var kerr error
+		if pending, kerr = g.publishKafka(processed, pending); err == nil {
+			err = kerr
 		}
-		if npub > 0 {
-			// wait for confirms that all records were received and routed
-			if err := w.Wait(g.Config.TimeoutDuration); err != nil {
-				return err
+	}
+	for _, i := range pending {
+		outcomes[i] = outcomePublished
+	}
+	return outcomes, err
+}
+
+// publishAMQP publishes recs[i], for each i in idx, to the AMQP output, and
+// returns the indexes of those that were confirmed. Records that can not be
+// encoded are skipped. Otherwise publishing stops at the first error, which
+// is returned.
+func (g *GraccCollector) publishAMQP(recs []gracc.Record, idx []int) ([]int, error) {
+	// setup AMQP channel
+	w, err := g.AMQPOutput.NewWorker(len(idx))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	defer w.Close()
+
+	var sent []int
+	for _, i := range idx {
+		if err = w.PublishRecord(recs[i]); err != nil {
+			if skipRecord(err) {
+				err = nil
+				continue
 			}
+			break
+		}
+		sent = append(sent, i)
+	}
+	if len(sent) > 0 {
+		// wait for confirms that all records were received and routed
+		if werr := w.Wait(g.Config.TimeoutDuration); werr != nil {
+			return nil, werr
 		}
 	}
+	return sent, err
+}
 
-	if g.Config.Kafka.Enable {
-		for rec := range bun.Records() {
-			if err := g.KafkaOutput.PublishRecord(rec); err != nil {
-				g.Events <- RECORD_ERROR
-				return err
+// publishKafka publishes recs[i], for each i in idx, to the Kafka output, and
+// returns the indexes of those that were sent. Records that can not be
+// encoded are skipped. Otherwise publishing stops at the first error, which
+// is returned.
+func (g *GraccCollector) publishKafka(recs []gracc.Record, idx []int) ([]int, error) {
+	var sent []int
+	for _, i := range idx {
+		if err := g.KafkaOutput.PublishRecord(recs[i]); err != nil {
+			if skipRecord(err) {
+				continue
 			}
+			return sent, err
 		}
+		sent = append(sent, i)
 	}
+	return sent, nil
+}
 
-	return nil
+// skipRecord logs err and returns true if it is a RecordError, i.e. the
+// record could not be encoded for an output (e.g. it is not a valid Usage
+// Record), so that it is counted as an error but the other records are
+// still sent.
+func skipRecord(err error) bool {
+	if _, ok := err.(RecordError); !ok {
+		return false
+	}
+	log.WithField("error", err).Error("error encoding record; skipping")
+	return true
+}
+
+// countOutcomes counts the records that were received, and those that were
+// not sent because of an error or a stage, in the collector stats. Records
+// of denied types are not counted.
+func (g *GraccCollector) countOutcomes(outcomes []sendOutcome) {
+	for _, o := range outcomes {
+		if o == outcomeDenied {
+			continue
+		}
+		g.Events <- GOT_RECORD
+		if o != outcomePublished {
+			g.Events <- RECORD_ERROR
+		}
+	}
+}
+
+// countDurationWarnings counts the invalid durations in rec.
+func (g *GraccCollector) countDurationWarnings(rec gracc.Record) {
+	errs := gracc.DurationErrors(rec)
+	if len(errs) == 0 {
+		return
+	}
+	g.m.Lock()
+	defer g.m.Unlock()
+	for _, e := range errs {
+		g.durationWarnings[e.Field]++
+	}
+	log.WithFields(log.Fields{
+		"type":  rec.Type(),
+		"id":    rec.Id(),
+		"error": errs[0],
+	}).Warning("record contains invalid durations")
 }
 
 func (g *GraccCollector) handleError(req *Request, err error) {
 	var msg string
 	var code int
 	switch err.(type) {
-	case AMQPError:
+	case AMQPError, OutputError:
 		code = 503
 		msg = "Service unavailable right now"
 	case RequestError:
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/opensciencegrid/gracc-collector/gracc"
 	"github.com/streadway/amqp"
 )
 
@@ -62,6 +68,8 @@ func TestMain(m *testing.M) {
 	// start HTTP server
 	http.Handle("/rmi", collector)
 	http.HandleFunc("/stats", collector.ServeStats)
+	http.HandleFunc("/probes", collector.ServeProbes)
+	http.HandleFunc("/tarball", collector.ServeTarball)
 	go http.ListenAndServe(config.Address+":"+config.Port, nil)
 
 	// start AMQP consumer
@@ -171,6 +179,285 @@ func TestMultiUpdate(t *testing.T) {
 	}
 }
 
+func TestUpdateSingleRecord(t *testing.T) {
+	testURL := "http://" + config.Address + ":" + config.Port + "/rmi"
+	rec, err := ioutil.ReadFile("gracc/test_data/JobUsageRecord01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := url.Values{}
+	v.Set("command", "update")
+	v.Set("from", "localhost")
+	v.Set("arg1", string(rec))
+	resp, err := http.PostForm(testURL, v)
+	if err != nil {
+		t.Error(err)
+	} else {
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Error(fmt.Errorf("update got response %s", resp.Status))
+		}
+	}
+}
+
+func TestRawUpdate(t *testing.T) {
+	testURL := "http://" + config.Address + ":" + config.Port + "/rmi"
+	rec, err := ioutil.ReadFile("gracc/test_data/StorageElement01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ct := range []string{"application/xml", "application/x-www-form-urlencoded"} {
+		for _, body := range []string{string(rec), testBundleXML, testBundle} {
+			resp, err := http.Post(testURL, ct, strings.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Error(fmt.Errorf("raw %s update got response %s", ct, resp.Status))
+			}
+		}
+	}
+}
+
+func TestProbeDetails(t *testing.T) {
+	testURL := "http://" + config.Address + ":" + config.Port
+	rec, err := ioutil.ReadFile("gracc/test_data/ProbeDetails01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(testURL+"/rmi", "application/xml", bytes.NewReader(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatal(fmt.Errorf("update got response %s", resp.Status))
+	}
+	resp, err = http.Get(testURL + "/probes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var probes map[string]ProbeInfo
+	if err := json.NewDecoder(resp.Body).Decode(&probes); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := probes["condor:osg-gw-7.t2.ucsd.edu"]
+	if !ok {
+		t.Fatal("probe not found")
+	}
+	if v := p.Versions["condor_meter"]; v != "1.16.3" {
+		t.Errorf("expected condor_meter version 1.16.3, got %q", v)
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	testURL := "http://" + config.Address + ":" + config.Port + "/rmi"
+	rec, err := ioutil.ReadFile("gracc/test_data/GenericRecord01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { config.Records = RecordsConfig{} }()
+	for _, tc := range []struct {
+		records RecordsConfig
+		status  int
+	}{
+		{RecordsConfig{}, 400},
+		{RecordsConfig{PassThrough: true}, 200},
+		{RecordsConfig{PassThrough: true, Deny: "FutureRecord"}, 200},
+		{RecordsConfig{PassThrough: true, Allow: "JobUsageRecord"}, 200},
+	} {
+		config.Records = tc.records
+		config.Records.Validate()
+		resp, err := http.Post(testURL, "application/xml", bytes.NewReader(rec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%+v: expected response %d, got %s", tc.records, tc.status, resp.Status)
+		}
+	}
+}
+
+func TestDurations(t *testing.T) {
+	testURL := "http://" + config.Address + ":" + config.Port + "/rmi"
+	rec, err := ioutil.ReadFile("gracc/test_data/JobUsageRecord08.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		config.Records = RecordsConfig{}
+		gracc.SetOptions(config.Records.jsonOptions())
+	}()
+	for _, tc := range []struct {
+		durations string
+		status    int
+	}{
+		{gracc.DurationsLenient, 200},
+		{gracc.DurationsStrict, 400},
+	} {
+		config.Records = RecordsConfig{Durations: tc.durations}
+		if err := gracc.SetOptions(config.Records.jsonOptions()); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(testURL, "application/xml", bytes.NewReader(rec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: expected response %d, got %s", tc.durations, tc.status, resp.Status)
+		}
+	}
+	collector.m.Lock()
+	n := collector.durationWarnings["WallDuration"]
+	collector.m.Unlock()
+	if n == 0 {
+		t.Error("expected WallDuration warning to be counted")
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	collector.AMQPOutput.Config.Format = "xml"
+	defer func() { collector.AMQPOutput.Config.Format = config.AMQP.Format }()
+	var recs []gracc.Record
+	// JobUsageRecord07 repeats Queue, so is not a valid Usage Record
+	for _, f := range []string{"gracc/test_data/JobUsageRecord07.xml", "gracc/test_data/JobUsageRecord01.xml"} {
+		buf, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := gracc.ParseRecordXML(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	outcomes, err := collector.sendRecords(recs, nil)
+	if err != nil {
+		t.Fatalf("expected invalid record to be skipped, got %s", err)
+	}
+	if len(outcomes) != 2 || outcomes[0] != outcomeFailed || outcomes[1] != outcomePublished {
+		t.Errorf("expected invalid record to fail and the other to be published, got %v", outcomes)
+	}
+}
+
+func TestRecordsAccept(t *testing.T) {
+	c := RecordsConfig{Deny: "ProbeDetails, MetricRecord"}
+	c.Validate()
+	if !c.Accept("JobUsageRecord") || c.Accept("ProbeDetails") || c.Accept("MetricRecord") {
+		t.Errorf("deny list not applied: %+v", c)
+	}
+	c = RecordsConfig{Allow: "JobUsageRecord", Deny: "JobUsageRecord"}
+	c.Validate()
+	if !c.Accept("JobUsageRecord") || c.Accept("StorageElement") {
+		t.Errorf("allow list not applied: %+v", c)
+	}
+}
+
+func TestTarball(t *testing.T) {
+	testURL := "http://" + config.Address + ":" + config.Port + "/tarball"
+	se, err := ioutil.ReadFile("gracc/test_data/StorageElement01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Records = RecordsConfig{Deny: "StorageElement"}
+	config.Records.Validate()
+	defer func() { config.Records = RecordsConfig{} }()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	files := []struct {
+		name     string
+		body     string
+		accepted int
+		rejected int
+		dropped  int
+		err      bool
+	}{
+		{"bundle.xml", testBundleXML, testBundleXMLSize, 0, 0, false},
+		{"replication.txt", testBundle, testBundleSize, 0, 0, false},
+		{"bad.xml", "<JobUsageRecord>", 0, 1, 0, true},
+		{"badbundle.xml", "<RecordEnvelope><JobUsageRecord/><StorageElement/><JobUsageRecord>", 0, 3, 0, true},
+		{"denied.xml", string(se), 0, 0, 1, false},
+		// unrecognized records alone in the final chunk
+		{"other.xml", "<RecordEnvelope><NotARecord/></RecordEnvelope>", 0, 1, 0, false},
+	}
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     f.name,
+			Mode:     0644,
+			Size:     int64(len(f.body)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gw.Close()
+	resp, err := http.Post(testURL, "application/gzip", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatal(fmt.Errorf("tarball got response %s", resp.Status))
+	}
+	var summary TarballSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Files) != len(files) {
+		t.Fatalf("expected summary of %d files, got %d", len(files), len(summary.Files))
+	}
+	for i, f := range files {
+		fs := summary.Files[i]
+		if fs.File != f.name {
+			t.Errorf("expected summary of file %s, got %s", f.name, fs.File)
+		}
+		if fs.Accepted != f.accepted {
+			t.Errorf("%s: expected %d records accepted, got %d", f.name, f.accepted, fs.Accepted)
+		}
+		if fs.Rejected != f.rejected {
+			t.Errorf("%s: expected %d records rejected, got %d", f.name, f.rejected, fs.Rejected)
+		}
+		if fs.Dropped != f.dropped {
+			t.Errorf("%s: expected %d records dropped, got %d", f.name, f.dropped, fs.Dropped)
+		}
+		if (fs.Error != "") != f.err {
+			t.Errorf("%s: unexpected error %q", f.name, fs.Error)
+		}
+	}
+}
+
+func TestCountRecords(t *testing.T) {
+	for _, tc := range []struct {
+		buf string
+		n   int
+	}{
+		{"<JobUsageRecord>", 1},
+		{"not a record", 1},
+		{"<RecordEnvelope><JobUsageRecord><Foo/></JobUsageRecord><StorageElement/><JobUsageRecord>", 3},
+		{`replication|<JobUsageRecord a="|"/>|raw|extra|replication|<JobUsageRecord>|raw|extra`, 2},
+	} {
+		if n := countRecords([]byte(tc.buf)); n != tc.n {
+			t.Errorf("%s: expected %d records, got %d", tc.buf, tc.n, n)
+		}
+	}
+	if n := countRecords([]byte(testBundle)); n != testBundleSize {
+		t.Errorf("test bundle: expected %d records, got %d", testBundleSize, n)
+	}
+	if n := countRecords([]byte(testBundleXML)); n != testBundleXMLSize {
+		t.Errorf("test XML bundle: expected %d records, got %d", testBundleXMLSize, n)
+	}
+}
+
 // Load test data
 var (
 	testBundleSize int
@@ -192,7 +479,8 @@ func init() {
 }
 
 var (
-	testBundleXML string
+	testBundleXMLSize int
+	testBundleXML     string
 )
 
 func init() {
@@ -206,4 +494,5 @@ func init() {
 		panic(err)
 	}
 	testBundleXML = buf.String()
+	testBundleXMLSize = 11
 }
@@ -12,15 +12,27 @@ import (
 )
 
 type CollectorConfig struct {
-	Address         string        `env:"GRACC_ADDRESS"`
-	Port            string        `env:"GRACC_PORT"`
-	Timeout         string        `env:"GRACC_TIMEOUT"`
-	TimeoutDuration time.Duration `env:"-"`
-	LogLevel        string        `env:"GRACC_LOGLEVEL"`
-	AMQP            AMQPConfig    `env:"GRACC_AMQP_"`
-	Kafka           KafkaConfig   `env:"GRACC_KAFKA_"`
-	StartBufferSize int           `env:"GRACC_STARTBUFFERSIZE"`
-	MaxBufferSize   int           `env:"GRACC_MAXBUFFERSIZE"`
+	Address          string           `env:"GRACC_ADDRESS"`
+	Port             string           `env:"GRACC_PORT"`
+	Timeout          string           `env:"GRACC_TIMEOUT"`
+	TimeoutDuration  time.Duration    `env:"-"`
+	LogLevel         string           `env:"GRACC_LOGLEVEL"`
+	AMQP             AMQPConfig       `env:"GRACC_AMQP_"`
+	Kafka            KafkaConfig      `env:"GRACC_KAFKA_"`
+	Spool            SpoolConfig      `env:"GRACC_SPOOL_"`
+	AMQPInput        AMQPInputConfig  `env:"GRACC_AMQPINPUT_"`
+	Records          RecordsConfig    `env:"GRACC_RECORDS_"`
+	TimeCheck        TimeCheckConfig  `env:"GRACC_TIMECHECK_"`
+	Topology         TopologyConfig   `env:"GRACC_TOPOLOGY_"`
+	VOMap            VOMapConfig      `env:"GRACC_VOMAP_"`
+	Benchmark        BenchmarkConfig  `env:"GRACC_BENCHMARK_"`
+	RateCard         RateCardConfig   `env:"GRACC_RATECARD_"`
+	Validation       ValidationConfig `env:"GRACC_VALIDATION_"`
+	StartBufferSize  int              `env:"GRACC_STARTBUFFERSIZE"`
+	MaxBufferSize    int              `env:"GRACC_MAXBUFFERSIZE"`
+	MaxRequestSize   int64            `env:"GRACC_MAXREQUESTSIZE"`
+	MaxUploadSize    int64            `env:"GRACC_MAXUPLOADSIZE"`
+	TarballChunkSize int              `env:"GRACC_TARBALLCHUNKSIZE"`
 }
 
 func DefaultConfig() *CollectorConfig {
@@ -52,6 +64,24 @@ func DefaultConfig() *CollectorConfig {
 			Topic:   "gracc",
 			Format:  "json",
 		},
+		AMQPInput: AMQPInputConfig{
+			Enable:   false,
+			Host:     "localhost",
+			Port:     "5672",
+			Scheme:   "amqp",
+			User:     "guest",
+			Password: "guest",
+			Queue:    "",
+			Prefetch: 100,
+			Retry:    "1s",
+			MaxRetry: "1h",
+		},
+		Spool: SpoolConfig{
+			Enable:   false,
+			Dirs:     "",
+			Pattern:  "*.xml",
+			Interval: "10s",
+		},
 		StartBufferSize: 4096,
 		MaxBufferSize:   512 * 1024,
 	}
@@ -69,6 +99,45 @@ func (c *CollectorConfig) Validate() error {
 	if err != nil {
 		return fmt.Errorf("error parsing Timeout: %s", err)
 	}
+	if c.MaxRequestSize <= 0 {
+		c.MaxRequestSize = 10 * 1024 * 1024
+	}
+	if c.MaxUploadSize <= 0 {
+		c.MaxUploadSize = 256 * 1024 * 1024
+	}
+	if c.TarballChunkSize <= 0 {
+		c.TarballChunkSize = 100
+	}
+	if err := c.Spool.Validate(); err != nil {
+		return err
+	}
+	if err := c.AMQPInput.Validate(); err != nil {
+		return err
+	}
+	if err := c.Records.Validate(); err != nil {
+		return err
+	}
+	if err := c.TimeCheck.Validate(); err != nil {
+		return err
+	}
+	if err := c.Topology.Validate(); err != nil {
+		return err
+	}
+	if err := c.VOMap.Validate(); err != nil {
+		return err
+	}
+	if err := c.Benchmark.Validate(); err != nil {
+		return err
+	}
+	if err := c.RateCard.Validate(); err != nil {
+		return err
+	}
+	if err := c.Validation.Validate(); err != nil {
+		return err
+	}
+	if err := c.Kafka.Validate(); err != nil {
+		return err
+	}
 	return c.AMQP.Validate()
 }
 
@@ -13,6 +13,20 @@ func (e AMQPError) Error() string {
 	return e.Message
 }
 
+// OutputError represents an error sending records to an output, other than
+// the AMQP broker, which may succeed if retried.
+type OutputError struct {
+	Message string
+}
+
+func NewOutputError(msg string) OutputError {
+	return OutputError{Message: msg}
+}
+
+func (e OutputError) Error() string {
+	return e.Message
+}
+
 // RequestError represents an error due to an invalid request.
 type RequestError struct {
 	Message string
@@ -8,11 +8,21 @@ import (
 // be sent from a probe.
 type RecordBundle struct {
 	XMLName               xml.Name               `xml:"RecordEnvelope"`
+	EMIComputeRecords     []ComputeRecord        `xml:"http://eu-emi.eu/namespaces/2012/11/computerecord UsageRecord,omitempty"`
 	UsageRecords          []JobUsageRecord       `xml:"UsageRecord,omitempty"`
 	JobUsageRecords       []JobUsageRecord       `xml:"JobUsageRecord,omitempty"`
 	StorageElements       []StorageElement       `xml:"StorageElement,omitempty"`
 	StorageElementRecords []StorageElementRecord `xml:"StorageElementRecord,omitempty"`
+	ComputeElements       []ComputeElement       `xml:"ComputeElement,omitempty"`
+	ComputeElementRecords []ComputeElementRecord `xml:"ComputeElementRecord,omitempty"`
+	Subclusters           []Subcluster           `xml:"Subcluster,omitempty"`
+	MetricRecords         []MetricRecord         `xml:"MetricRecord,omitempty"`
+	ProbeDetails          []ProbeDetails         `xml:"ProbeDetails,omitempty"`
+	ComputeRecords        []ComputeRecord        `xml:"ComputeRecord,omitempty"`
+	StorageRecords        []StorageRecord        `xml:"StorageRecord,omitempty"`
+	EMIStorageRecords     []StorageRecord        `xml:"StorageUsageRecord,omitempty"`
 	OtherRecords          []XMLRecord            `xml:",omitempty,any"`
+	GenericRecords        []GenericRecord        `xml:"-"`
 }
 
 // RecordCount returns the total number of records in the bundle.
@@ -21,6 +31,16 @@ func (b *RecordBundle) RecordCount() int {
 		len(b.JobUsageRecords) +
 		len(b.StorageElements) +
 		len(b.StorageElementRecords) +
+		len(b.ComputeElements) +
+		len(b.ComputeElementRecords) +
+		len(b.Subclusters) +
+		len(b.MetricRecords) +
+		len(b.ProbeDetails) +
+		len(b.ComputeRecords) +
+		len(b.StorageRecords) +
+		len(b.EMIComputeRecords) +
+		len(b.EMIStorageRecords) +
+		len(b.GenericRecords) +
 		len(b.OtherRecords)
 }
 
@@ -41,9 +61,54 @@ func (b *RecordBundle) Records() chan Record {
 	for i, _ := range b.StorageElementRecords {
 		recs <- &b.StorageElementRecords[i]
 	}
+	for i, _ := range b.ComputeElements {
+		recs <- &b.ComputeElements[i]
+	}
+	for i, _ := range b.ComputeElementRecords {
+		recs <- &b.ComputeElementRecords[i]
+	}
+	for i, _ := range b.Subclusters {
+		recs <- &b.Subclusters[i]
+	}
+	for i, _ := range b.MetricRecords {
+		recs <- &b.MetricRecords[i]
+	}
+	for i, _ := range b.ProbeDetails {
+		recs <- &b.ProbeDetails[i]
+	}
+	for i, _ := range b.ComputeRecords {
+		recs <- &b.ComputeRecords[i]
+	}
+	for i, _ := range b.StorageRecords {
+		recs <- &b.StorageRecords[i]
+	}
+	for i, _ := range b.EMIComputeRecords {
+		recs <- &b.EMIComputeRecords[i]
+	}
+	for i, _ := range b.EMIStorageRecords {
+		recs <- &b.EMIStorageRecords[i]
+	}
+	for i, _ := range b.GenericRecords {
+		recs <- &b.GenericRecords[i]
+	}
 	return recs
 }
 
+// PassThrough converts all OtherRecords to GenericRecords, so that they
+// are included in Records(). Records that can not be converted are left
+// in OtherRecords.
+func (b *RecordBundle) PassThrough() {
+	var other []XMLRecord
+	for _, x := range b.OtherRecords {
+		if gr, err := x.Generic(); err == nil {
+			b.GenericRecords = append(b.GenericRecords, *gr)
+		} else {
+			other = append(other, x)
+		}
+	}
+	b.OtherRecords = other
+}
+
 // AddRecord attempts to coerce rec into a known type and add it to the
 // appropriate list. Otherwise, it is added to OtherRecords.
 func (b *RecordBundle) AddRecord(rec Record) error {
@@ -54,6 +119,22 @@ func (b *RecordBundle) AddRecord(rec Record) error {
 		b.StorageElements = append(b.StorageElements, *rec.(*StorageElement))
 	case *StorageElementRecord:
 		b.StorageElementRecords = append(b.StorageElementRecords, *rec.(*StorageElementRecord))
+	case *ComputeElement:
+		b.ComputeElements = append(b.ComputeElements, *rec.(*ComputeElement))
+	case *ComputeElementRecord:
+		b.ComputeElementRecords = append(b.ComputeElementRecords, *rec.(*ComputeElementRecord))
+	case *Subcluster:
+		b.Subclusters = append(b.Subclusters, *rec.(*Subcluster))
+	case *MetricRecord:
+		b.MetricRecords = append(b.MetricRecords, *rec.(*MetricRecord))
+	case *ProbeDetails:
+		b.ProbeDetails = append(b.ProbeDetails, *rec.(*ProbeDetails))
+	case *ComputeRecord:
+		b.ComputeRecords = append(b.ComputeRecords, *rec.(*ComputeRecord))
+	case *StorageRecord:
+		b.StorageRecords = append(b.StorageRecords, *rec.(*StorageRecord))
+	case *GenericRecord:
+		b.GenericRecords = append(b.GenericRecords, *rec.(*GenericRecord))
 	default:
 		b.OtherRecords = append(b.OtherRecords, XMLRecord{
 			XMLName:  xml.Name{Local: rec.Type()},
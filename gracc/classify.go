@@ -0,0 +1,76 @@
+package gracc
+
+import (
+	"fmt"
+	"path"
+)
+
+// ClassifyRule sets fields of JobUsageRecords that match it, such as
+// ResourceType or other classification fields.
+type ClassifyRule struct {
+	// Match maps raw record fields to shell patterns (see path.Match) that
+	// they must all match. A missing field matches as "". ResourceType is the
+	// value reported by the probe, or as set by an earlier rule.
+	Match map[string]string
+	// Set maps raw record fields to the values they are set to.
+	Set map[string]string
+}
+
+// DefaultClassifyRules are used if no rules are configured. A missing
+// ResourceType is "Batch", and "BatchPilot" is renamed "Payload" due
+// to the former being misleading.
+var DefaultClassifyRules = []ClassifyRule{
+	{
+		Match: map[string]string{"ResourceType": ""},
+		Set:   map[string]string{"ResourceType": "Batch"},
+	},
+	{
+		Match: map[string]string{"ResourceType": "BatchPilot"},
+		Set:   map[string]string{"ResourceType": "Payload"},
+	},
+}
+
+// checkClassifyRules returns an error if any rule has a bad pattern.
+func checkClassifyRules(rules []ClassifyRule) error {
+	for i, c := range rules {
+		for k, p := range c.Match {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("classify rule %d: bad pattern %q for %s", i+1, p, k)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *ClassifyRule) matches(r map[string]interface{}) bool {
+	for k, p := range c.Match {
+		var s string
+		switch v := r[k].(type) {
+		case nil:
+		case string:
+			s = v
+		default:
+			s = fmt.Sprint(v)
+		}
+		if ok, _ := path.Match(p, s); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// classify applies the configured rules to r, in order. Each rule
+// is matched against r as modified by the rules before it.
+func classify(r map[string]interface{}) {
+	rules := options.Classify
+	if rules == nil {
+		rules = DefaultClassifyRules
+	}
+	for i := range rules {
+		if rules[i].matches(r) {
+			for k, v := range rules[i].Set {
+				r[k] = v
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package gracc
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+)
+
+// ComputeElement is a flexible container for compute element (CE) status information.
+type ComputeElement struct {
+	XMLName          xml.Name
+	Attrs            rootAttrs `xml:",any,attr"`
+	UniqueID         string    `xml:",omitempty"`
+	Timestamp        time.Time `xml:",omitempty"`
+	MaxRunningJobs   uint64    `xml:",omitempty"`
+	MaxTotalJobs     uint64    `xml:",omitempty"`
+	AssignedJobSlots uint64    `xml:",omitempty"`
+	Origin           origin    `xml:",omitempty"`
+	Fields           fieldList `xml:",any"`
+	RawXML           []byte    `xml:",innerxml"`
+}
+
+// ParseXML attempts to unmarshal the XML in xb into a ComputeElement.
+func (ce *ComputeElement) ParseXML(xb []byte) error {
+	if err := xml.Unmarshal(xb, ce); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Id returns an identification string for the record.
+func (ce *ComputeElement) Id() string {
+	return ce.UniqueID
+}
+
+// Type returns the type of the record.
+func (ce *ComputeElement) Type() string {
+	return ce.XMLName.Local
+}
+
+// Raw returns the unaltered source of the record.
+func (ce *ComputeElement) Raw() []byte {
+	s := "<" + ce.XMLName.Local + ">" + string(ce.RawXML) + "</" + ce.XMLName.Local + ">"
+	return []byte(s)
+}
+
+// ToURJSON returns a JSON encoding of the Record that keeps the
+// structure of the Usage Record XML.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (ce *ComputeElement) ToURJSON(indent string) ([]byte, error) {
+	return toURJSON(ce.XMLName, ce.Attrs, ce.RawXML, indent)
+}
+
+// ToXML returns the record as canonical XML, with its namespaces
+// and extension elements.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (ce *ComputeElement) ToXML(indent string) ([]byte, error) {
+	return toURXML(ce.XMLName, ce.Attrs, ce.RawXML, indent)
+}
+
+func (ce *ComputeElement) durations() []namedDuration {
+	return ce.Fields.durations()
+}
+
+// ToJSON returns a JSON encoding of the Record, with certain elements
+// transformed to fit the GRACC Raw Record schema.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (ce *ComputeElement) ToJSON(indent string) ([]byte, error) {
+	var r = make(map[string]interface{})
+
+	r["type"] = "ComputeElement"
+
+	r["UniqueID"] = ce.UniqueID
+
+	// Standard time instants
+	if !ce.Timestamp.IsZero() {
+		r["Timestamp"] = ce.Timestamp.Format(time.RFC3339)
+	}
+
+	// data fields
+	if ce.MaxRunningJobs > 0 {
+		r["MaxRunningJobs"] = ce.MaxRunningJobs
+	}
+	if ce.MaxTotalJobs > 0 {
+		r["MaxTotalJobs"] = ce.MaxTotalJobs
+	}
+	if ce.AssignedJobSlots > 0 {
+		r["AssignedJobSlots"] = ce.AssignedJobSlots
+	}
+
+	// flatten other fields
+	for k, v := range ce.Fields.flatten() {
+		r[k] = v
+	}
+
+	// origin
+	for k, v := range ce.Origin.flatten() {
+		r[k] = v
+	}
+
+	// warnings
+	addWarnings(r, ce)
+
+	// add XML
+	r["RawXML"] = string(ce.Raw())
+
+	if indent != "" {
+		return json.MarshalIndent(r, "", indent)
+	}
+	return json.Marshal(r)
+}
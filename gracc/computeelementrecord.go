@@ -0,0 +1,108 @@
+package gracc
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+)
+
+// ComputeElementRecord is a flexible container for compute element (CE) job count information.
+type ComputeElementRecord struct {
+	XMLName     xml.Name
+	Attrs       rootAttrs `xml:",any,attr"`
+	UniqueID    string    `xml:",omitempty"`
+	Timestamp   time.Time `xml:",omitempty"`
+	RunningJobs uint64    `xml:",omitempty"`
+	WaitingJobs uint64    `xml:",omitempty"`
+	TotalJobs   uint64    `xml:",omitempty"`
+	Origin      origin    `xml:",omitempty"`
+	Fields      fieldList `xml:",any"`
+	RawXML      []byte    `xml:",innerxml"`
+}
+
+// ParseXML attempts to unmarshal the XML in xb into a ComputeElementRecord.
+func (cer *ComputeElementRecord) ParseXML(xb []byte) error {
+	if err := xml.Unmarshal(xb, cer); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Id returns an identification string for the record.
+func (cer *ComputeElementRecord) Id() string {
+	return cer.UniqueID
+}
+
+// Type returns the type of the record.
+func (cer *ComputeElementRecord) Type() string {
+	return cer.XMLName.Local
+}
+
+// Raw returns the unaltered source of the record.
+func (cer *ComputeElementRecord) Raw() []byte {
+	s := "<" + cer.XMLName.Local + ">" + string(cer.RawXML) + "</" + cer.XMLName.Local + ">"
+	return []byte(s)
+}
+
+// ToURJSON returns a JSON encoding of the Record that keeps the
+// structure of the Usage Record XML.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (cer *ComputeElementRecord) ToURJSON(indent string) ([]byte, error) {
+	return toURJSON(cer.XMLName, cer.Attrs, cer.RawXML, indent)
+}
+
+// ToXML returns the record as canonical XML, with its namespaces
+// and extension elements.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (cer *ComputeElementRecord) ToXML(indent string) ([]byte, error) {
+	return toURXML(cer.XMLName, cer.Attrs, cer.RawXML, indent)
+}
+
+func (cer *ComputeElementRecord) durations() []namedDuration {
+	return cer.Fields.durations()
+}
+
+// ToJSON returns a JSON encoding of the Record, with certain elements
+// transformed to fit the GRACC Raw Record schema.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (cer *ComputeElementRecord) ToJSON(indent string) ([]byte, error) {
+	var r = make(map[string]interface{})
+
+	r["type"] = "ComputeElementRecord"
+
+	r["UniqueID"] = cer.UniqueID
+
+	// Standard time instants
+	if !cer.Timestamp.IsZero() {
+		r["Timestamp"] = cer.Timestamp.Format(time.RFC3339)
+	}
+
+	// job counts are always included, since zero is meaningful here
+	r["RunningJobs"] = cer.RunningJobs
+	r["WaitingJobs"] = cer.WaitingJobs
+	r["TotalJobs"] = cer.TotalJobs
+
+	// flatten other fields
+	for k, v := range cer.Fields.flatten() {
+		r[k] = v
+	}
+
+	// origin
+	for k, v := range cer.Origin.flatten() {
+		r[k] = v
+	}
+
+	// warnings
+	addWarnings(r, cer)
+
+	// add XML
+	r["RawXML"] = string(cer.Raw())
+
+	if indent != "" {
+		return json.MarshalIndent(r, "", indent)
+	}
+	return json.Marshal(r)
+}
@@ -0,0 +1,239 @@
+package gracc
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"time"
+)
+
+// UR2Version is the value of the ur_version field for records
+// parsed from OGF Usage Record 2.0 documents.
+const UR2Version = "2.0"
+
+type groupAttribute struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// ur2UserIdentity is the UR 2.0 UserIdentity (ComputeRecord)
+// or SubjectIdentity (StorageRecord) block.
+type ur2UserIdentity struct {
+	GlobalUserName string
+	UserIdentity   string
+	LocalUserId    string
+	LocalUser      string
+	LocalGroup     string
+	Group          string
+	GroupAttribute []groupAttribute
+}
+
+// flatten maps the identity to the GRACC user identity fields.
+// The Group is used as the VO, and a GlobalUserName that looks
+// like a certificate subject is also used as the DN.
+func (i *ur2UserIdentity) flatten() map[string]interface{} {
+	var r = make(map[string]interface{})
+	for _, u := range []string{i.GlobalUserName, i.UserIdentity} {
+		if u != "" {
+			r["GlobalUsername"] = u
+			if strings.HasPrefix(u, "/") {
+				r["DN"] = u
+			}
+		}
+	}
+	for _, u := range []string{i.LocalUserId, i.LocalUser} {
+		if u != "" {
+			r["LocalUserId"] = u
+		}
+	}
+	if i.LocalGroup != "" {
+		r["LocalGroup"] = i.LocalGroup
+	}
+	if i.Group != "" {
+		r["VOName"] = i.Group
+		r["ReportableVOName"] = i.Group
+	}
+	for _, a := range i.GroupAttribute {
+		k := "GroupAttribute"
+		if a.Type != "" {
+			k += "_" + strings.Map(mapForKey, a.Type)
+		}
+		r[k] = a.Value
+	}
+	return r
+}
+
+type ur2CpuDuration struct {
+	UsageType string `xml:"usageType,attr"`
+	Value     string `xml:",chardata"`
+}
+
+// ComputeRecord is an OGF Usage Record 2.0 compute (job) record, also
+// known as the EMI Compute Accounting Record.
+// It is converted to the same raw record schema as a JobUsageRecord.
+type ComputeRecord struct {
+	XMLName        xml.Name
+	Attrs          rootAttrs        `xml:",any,attr"`
+	RecordIdentity recordIdentity   `xml:",omitempty"`
+	JobIdentity    jobIdentity      `xml:",omitempty"`
+	UserIdentity   ur2UserIdentity  `xml:",omitempty"`
+	Status         string           `xml:",omitempty"`
+	ExitStatus     string           `xml:",omitempty"`
+	WallDuration   string           `xml:",omitempty"`
+	CpuDuration    []ur2CpuDuration `xml:",omitempty"`
+	StartTime      time.Time        `xml:",omitempty"`
+	EndTime        time.Time        `xml:",omitempty"`
+	Site           string           `xml:",omitempty"`
+	Origin         origin           `xml:",omitempty"`
+	Fields         genericFieldList `xml:",any"`
+	RawXML         []byte           `xml:",innerxml"`
+}
+
+// ParseXML attempts to unmarshal the XML in xb into a ComputeRecord.
+func (cr *ComputeRecord) ParseXML(xb []byte) error {
+	if err := xml.Unmarshal(xb, cr); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Id returns an identification string for the record.
+func (cr *ComputeRecord) Id() string {
+	return cr.RecordIdentity.RecordId
+}
+
+// Type returns the type of the record, which is that of the schema it is
+// mapped to.
+func (cr *ComputeRecord) Type() string {
+	return "JobUsageRecord"
+}
+
+// Raw returns the unaltered source of the record.
+func (cr *ComputeRecord) Raw() []byte {
+	s := "<" + cr.XMLName.Local + ">" + string(cr.RawXML) + "</" + cr.XMLName.Local + ">"
+	return []byte(s)
+}
+
+// ToURJSON returns a JSON encoding of the Record that keeps the
+// structure of the Usage Record XML.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (cr *ComputeRecord) ToURJSON(indent string) ([]byte, error) {
+	return toURJSON(cr.XMLName, cr.Attrs, cr.RawXML, indent)
+}
+
+// ToXML returns the record as canonical Usage Record XML, with its
+// namespaces and extension elements, or a *StructureError if it is not a
+// valid Usage Record.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (cr *ComputeRecord) ToXML(indent string) ([]byte, error) {
+	return toURXML(cr.XMLName, cr.Attrs, cr.RawXML, indent)
+}
+
+func (cr *ComputeRecord) durations() []namedDuration {
+	d := []namedDuration{{"WallDuration", cr.WallDuration}}
+	for _, c := range cr.CpuDuration {
+		d = append(d, namedDuration{"CpuDuration", c.Value})
+	}
+	if len(cr.CpuDuration) == 0 {
+		d = append(d, namedDuration{"CpuDuration", ""})
+	}
+	return append(d, cr.Fields.durations()...)
+}
+
+// ToJSON returns a JSON encoding of the Record, with elements mapped
+// to the GRACC Raw Record schema for a JobUsageRecord.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (cr *ComputeRecord) ToJSON(indent string) ([]byte, error) {
+	var r = make(map[string]interface{})
+
+	// flatten other fields first, so that they don't clobber mapped fields
+	for k, v := range cr.Fields.flatten() {
+		r[k] = v
+	}
+
+	r["type"] = "JobUsageRecord"
+	r["ur_version"] = UR2Version
+
+	// Flatten identity blocks
+	for k, v := range cr.RecordIdentity.flatten() {
+		r[k] = v
+	}
+	for k, v := range cr.JobIdentity.flatten() {
+		r[k] = v
+	}
+	for k, v := range cr.UserIdentity.flatten() {
+		r[k] = v
+	}
+
+	// Status in UR 2.0 is a state (e.g. "completed"), while Gratia
+	// reports the exit code as Status.
+	if cr.ExitStatus != "" {
+		r["Status"] = cr.ExitStatus
+	}
+	if cr.Status != "" {
+		r["Status_description"] = cr.Status
+	}
+	if cr.Site != "" {
+		r["SiteName"] = cr.Site
+	}
+
+	// Standard time instants
+	if !cr.StartTime.IsZero() {
+		r["StartTime"] = cr.StartTime.Format(time.RFC3339)
+	}
+	if !cr.EndTime.IsZero() {
+		r["EndTime"] = cr.EndTime.Format(time.RFC3339)
+	}
+
+	// Standard durations. UR 2.0 allows usageType "all" in place of
+	// separate user and system durations.
+	var totalCpu float64
+	for _, c := range cr.CpuDuration {
+		secs := convertDurationToSeconds(c.Value)
+		switch c.UsageType {
+		case "", "all":
+			totalCpu = secs
+		default:
+			r["CpuDuration_"+c.UsageType] = secs
+		}
+	}
+	if totalCpu == 0 {
+		for _, c := range cr.CpuDuration {
+			if c.UsageType == "user" || c.UsageType == "system" {
+				totalCpu += convertDurationToSeconds(c.Value)
+			}
+		}
+	}
+	r["CpuDuration"] = totalCpu
+	r["WallDuration"] = convertDurationToSeconds(cr.WallDuration)
+
+	// origin
+	for k, v := range cr.Origin.flatten() {
+		r[k] = v
+	}
+
+	// ResourceType and other classification
+	classify(r)
+
+	// GPU usage
+	addGPU(r)
+
+	// derived usage
+	if options.Derived {
+		addDerived(r)
+	}
+
+	// warnings
+	addWarnings(r, cr)
+
+	// add XML
+	r["RawXML"] = string(cr.Raw())
+
+	if indent != "" {
+		return json.MarshalIndent(r, "", indent)
+	}
+	return json.Marshal(r)
+}
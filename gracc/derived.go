@@ -0,0 +1,85 @@
+package gracc
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Sources of the number of cores of a job, as given by CoreCount.
+const (
+	// CoresProcessors is the total Processors of the job (metric "total",
+	// or no metric).
+	CoresProcessors = "Processors"
+	// CoresPerNode is Processors per node (any other metric, such as "max"),
+	// times NodeCount (1 if missing).
+	CoresPerNode = "ProcessorsPerNode"
+	// CoresDefault is 1, as Processors is missing, zero or not a number.
+	CoresDefault = "default"
+)
+
+// Number returns raw record field value x as a number, if it is a number or a
+// string that parses as one.
+func Number(x interface{}) (float64, bool) {
+	switch v := x.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// CoreCount returns the number of cores used by the job of raw JobUsageRecord
+// r, and its source (CoresProcessors, CoresPerNode or CoresDefault).
+//
+// Processors is the total over all nodes if its metric is "total" or not set,
+// and otherwise per node. In summary records (Njobs > 1) Processors is per job,
+// while WallDuration and CpuDuration are totals over all jobs, so the number
+// of cores per job is returned.
+func CoreCount(r map[string]interface{}) (float64, string) {
+	p, ok := Number(r["Processors"])
+	if !ok || p <= 0 {
+		return 1, CoresDefault
+	}
+	switch r["Processors_metric"] {
+	case nil, "", "total":
+		return p, CoresProcessors
+	}
+	if n, ok := Number(r["NodeCount"]); ok && n > 0 {
+		p *= n
+	}
+	return p, CoresPerNode
+}
+
+// CoreHours returns the WallDuration of all cores (see CoreCount) of raw
+// JobUsageRecord r, in hours: the CoreHours derived field if it has been
+// added, or else computed in the same way.
+func CoreHours(r map[string]interface{}) float64 {
+	if h, ok := Number(r["CoreHours"]); ok {
+		return h
+	}
+	cores, _ := CoreCount(r)
+	wall, _ := Number(r["WallDuration"])
+	return wall * cores / 3600
+}
+
+// addDerived adds the fields derived from the usage of raw JobUsageRecord r:
+// Cores and CoresSource, as given by CoreCount; CoreHours, the WallDuration
+// times Cores in hours; and CpuEfficiency, the CpuDuration over WallDuration
+// times Cores, if WallDuration is not zero.
+func addDerived(r map[string]interface{}) {
+	cores, source := CoreCount(r)
+	wall, _ := Number(r["WallDuration"])
+	r["Cores"] = cores
+	r["CoresSource"] = source
+	r["CoreHours"] = wall * cores / 3600
+	if cpu, ok := Number(r["CpuDuration"]); ok && wall > 0 {
+		r["CpuEfficiency"] = cpu / (wall * cores)
+	}
+}
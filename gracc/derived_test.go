@@ -0,0 +1,153 @@
+package gracc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+func TestDerived(t *testing.T) {
+	defer SetOptions(Options{})
+	for _, tt := range []struct {
+		file       string
+		numeric    bool
+		cores      float64
+		source     string
+		coreHours  float64
+		efficiency float64
+	}{
+		{"test_data/JobUsageRecord01.xml", false, 1, CoresProcessors, 1, 65.32 / 3600},
+		{"test_data/JobUsageRecord01.xml", true, 1, CoresProcessors, 1, 65.32 / 3600},
+		{"test_data/JobUsageRecord02.xml", false, 4, CoresProcessors, 4 / 3600.0, 0.0},
+		{"test_data/JobUsageRecord05.xml", false, 1, CoresPerNode, 5 / 3600.0, 0.0},
+		{"test_data/JobUsageRecord06.xml", true, 1, CoresPerNode, 617 / 3600.0, 18 / 617.0},
+		{"test_data/ComputeRecord01.xml", false, 8, CoresProcessors, 8, 3030.5 / (3600 * 8)},
+	} {
+		if err := SetOptions(Options{Derived: true, NumericValues: tt.numeric}); err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadFile(tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := ParseRecordXML(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j, err := v.ToJSON("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r map[string]interface{}
+		if err := json.Unmarshal(j, &r); err != nil {
+			t.Fatal(err)
+		}
+		if r["Cores"] != tt.cores || r["CoresSource"] != tt.source {
+			t.Errorf("%s: expected %v cores from %s, got %v from %v", tt.file, tt.cores, tt.source, r["Cores"], r["CoresSource"])
+		}
+		if r["CoreHours"] != tt.coreHours {
+			t.Errorf("%s: expected CoreHours %v, got %v", tt.file, tt.coreHours, r["CoreHours"])
+		}
+		if e, ok := r["CpuEfficiency"].(float64); !ok || math.Abs(e-tt.efficiency) > 1e-12 {
+			t.Errorf("%s: expected CpuEfficiency %v, got %v", tt.file, tt.efficiency, r["CpuEfficiency"])
+		}
+	}
+
+	// not added by default
+	SetOptions(Options{})
+	buf, err := ioutil.ReadFile("test_data/JobUsageRecord01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ParseRecordXML(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := v.ToJSON("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r map[string]interface{}
+	if err := json.Unmarshal(j, &r); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"Cores", "CoresSource", "CoreHours", "CpuEfficiency"} {
+		if _, ok := r[k]; ok {
+			t.Errorf("derived field %s added by default", k)
+		}
+	}
+}
+
+func TestCoreCount(t *testing.T) {
+	for _, tt := range []struct {
+		r      map[string]interface{}
+		cores  float64
+		source string
+	}{
+		{map[string]interface{}{}, 1, CoresDefault},
+		{map[string]interface{}{"Processors": "0"}, 1, CoresDefault},
+		{map[string]interface{}{"Processors": "many"}, 1, CoresDefault},
+		{map[string]interface{}{"Processors": []interface{}{"2", "4"}}, 1, CoresDefault},
+		{map[string]interface{}{"Processors": "8", "Processors_metric": "total", "NodeCount": "2"}, 8, CoresProcessors},
+		{map[string]interface{}{"Processors": json.Number("8"), "NodeCount": 2.0}, 8, CoresProcessors},
+		{map[string]interface{}{"Processors": "8", "Processors_metric": "max", "NodeCount": "2"}, 16, CoresPerNode},
+		{map[string]interface{}{"Processors": 8.0, "Processors_metric": "average"}, 8, CoresPerNode},
+		// summary record: Processors per job
+		{map[string]interface{}{"Processors": "2", "Njobs": "10"}, 2, CoresProcessors},
+	} {
+		if cores, source := CoreCount(tt.r); cores != tt.cores || source != tt.source {
+			t.Errorf("%v: expected %v cores from %s, got %v from %s", tt.r, tt.cores, tt.source, cores, source)
+		}
+	}
+
+	// summary records: WallDuration is the total of all jobs
+	r := map[string]interface{}{"Processors": "2", "Njobs": "10", "WallDuration": 36000.0, "CpuDuration": 36000.0}
+	addDerived(r)
+	if r["CoreHours"] != 20.0 || r["CpuEfficiency"] != 0.5 {
+		t.Errorf("unexpected derived fields for summary record: %v", r)
+	}
+
+	r = map[string]interface{}{"WallDuration": 0.0, "CpuDuration": 0.0}
+	addDerived(r)
+	if _, ok := r["CpuEfficiency"]; ok || r["CoreHours"] != 0.0 {
+		t.Errorf("unexpected derived fields for record with no WallDuration: %v", r)
+	}
+}
+
+func TestCoreHours(t *testing.T) {
+	for _, tt := range []struct {
+		r     map[string]interface{}
+		hours float64
+	}{
+		{map[string]interface{}{"WallDuration": 7200.0}, 2},
+		{map[string]interface{}{"WallDuration": "3600", "Processors": "8", "Processors_metric": "max", "NodeCount": "2"}, 16},
+		// the derived field is used if it has been added
+		{map[string]interface{}{"WallDuration": 7200.0, "Processors": "4", "CoreHours": 1.5}, 1.5},
+		{map[string]interface{}{}, 0},
+	} {
+		if hours := CoreHours(tt.r); hours != tt.hours {
+			t.Errorf("%v: expected %v core hours, got %v", tt.r, tt.hours, hours)
+		}
+	}
+}
+
+func TestNumber(t *testing.T) {
+	for _, tt := range []struct {
+		x  interface{}
+		f  float64
+		ok bool
+	}{
+		{2.5, 2.5, true},
+		{3, 3, true},
+		{json.Number("1e3"), 1000, true},
+		{"42", 42, true},
+		{"PT1H", 0, false},
+		{nil, 0, false},
+		{[]interface{}{"1"}, 0, false},
+	} {
+		if f, ok := Number(tt.x); f != tt.f || ok != tt.ok {
+			t.Errorf("%#v: expected %v, %v, got %v, %v", tt.x, tt.f, tt.ok, f, ok)
+		}
+	}
+}
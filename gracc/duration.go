@@ -0,0 +1,143 @@
+package gracc
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Ways to handle durations that can not be parsed.
+const (
+	// DurationsLenient converts invalid durations to zero, and lists them
+	// in the "_warnings" field of the JSON record.
+	DurationsLenient = "lenient"
+	// DurationsStrict rejects records with invalid durations.
+	DurationsStrict = "strict"
+)
+
+var (
+	isoDuration = regexp.MustCompile(`^P(?:([\d.]+)Y)?(?:([\d.]+)M)?(?:([\d.]+)W)?(?:([\d.]+)D)?(?:T(?:([\d.]+)H)?(?:([\d.]+)M)?(?:([\d.]+)S)?)?$`)
+	// seconds in each part of isoDuration; as is common, a year is
+	// 365 days and a month 30 days.
+	isoDurationParts = []float64{365 * 86400, 30 * 86400, 7 * 86400, 86400, 3600, 60, 1}
+)
+
+// ParseDuration parses an ISO 8601 duration (e.g. "PT1H30M") and returns
+// the number of seconds. Besides the standard format, it accepts the quirks
+// seen in Gratia records: fractional values (e.g. "PT10M17.5S", or with a
+// comma), zero-valued parts (e.g. "P0DT0H1M"), lowercase designators
+// (e.g. "pt1h"), and a plain number of seconds (e.g. "3600").
+func ParseDuration(s string) (float64, error) {
+	s = strings.Replace(strings.ToUpper(strings.TrimSpace(s)), ",", ".", -1)
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && secs >= 0 && !strings.ContainsAny(s, "EINX") {
+		return secs, nil
+	}
+	m := isoDuration.FindStringSubmatch(s)
+	if m == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var secs float64
+	for i, part := range m[1:] {
+		if part == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		secs += v * isoDurationParts[i]
+	}
+	return secs, nil
+}
+
+func convertDurationToSeconds(dur string) float64 {
+	secs, err := ParseDuration(dur)
+	if err != nil {
+		return 0.0
+	}
+	return secs
+}
+
+// DurationError is a duration in a record that could not be parsed.
+type DurationError struct {
+	Field string
+	Value string
+}
+
+func (e *DurationError) Error() string {
+	return fmt.Sprintf("%s: invalid duration %q", e.Field, e.Value)
+}
+
+type namedDuration struct {
+	field string
+	value string
+}
+
+// durationer is implemented by records with duration elements or attributes.
+type durationer interface {
+	durations() []namedDuration
+}
+
+// DurationErrors returns the durations in rec that could not be parsed.
+func DurationErrors(rec Record) []*DurationError {
+	d, ok := rec.(durationer)
+	if !ok {
+		return nil
+	}
+	var errs []*DurationError
+	for _, nd := range d.durations() {
+		if nd.value == "" {
+			continue
+		}
+		if _, err := ParseDuration(nd.value); err != nil {
+			errs = append(errs, &DurationError{Field: nd.field, Value: nd.value})
+		}
+	}
+	return errs
+}
+
+// DurationValues returns the durations in rec as given in the record (e.g.
+// "PT1H"), by field. Durations that are absent or empty, which are zero in the
+// JSON record, are included with no values.
+func DurationValues(rec Record) map[string][]string {
+	d, ok := rec.(durationer)
+	if !ok {
+		return nil
+	}
+	var v = make(map[string][]string)
+	for _, nd := range d.durations() {
+		if nd.value == "" {
+			if _, ok := v[nd.field]; !ok {
+				v[nd.field] = nil
+			}
+			continue
+		}
+		v[nd.field] = append(v[nd.field], nd.value)
+	}
+	return v
+}
+
+// checkDurations returns the first duration error in rec,
+// if durations are strict.
+func checkDurations(rec Record) error {
+	if options.Durations != DurationsStrict {
+		return nil
+	}
+	if errs := DurationErrors(rec); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// addWarnings adds any duration errors in rec to the "_warnings"
+// field of r.
+func addWarnings(r map[string]interface{}, rec Record) {
+	var warnings []string
+	for _, e := range DurationErrors(rec) {
+		warnings = append(warnings, e.Error())
+	}
+	if len(warnings) > 0 {
+		r["_warnings"] = warnings
+	}
+}
@@ -0,0 +1,52 @@
+package gracc
+
+import (
+	"testing"
+)
+
+func TestParseDuration(t *testing.T) {
+	for _, dt := range []struct {
+		dur  string
+		secs float64
+	}{
+		{"PT1H", 3600},
+		{"PT10M17.0S", 617},
+		{"PT0.25S", 0.25},
+		{"PT1,5S", 1.5},
+		{"P0DT1H0M0S", 3600},
+		{"P1DT1S", 86401},
+		{"P1W", 604800},
+		{"P1Y2M", 365*86400 + 2*30*86400},
+		{"pt1h30m", 5400},
+		{" PT5S\n", 5},
+		{"3600", 3600},
+		{"12.5", 12.5},
+		{"0", 0},
+	} {
+		if secs, err := ParseDuration(dt.dur); err != nil {
+			t.Errorf("%q: %s", dt.dur, err)
+		} else if secs != dt.secs {
+			t.Errorf("%q: expected %v, got %v", dt.dur, dt.secs, secs)
+		}
+	}
+	for _, dur := range []string{
+		"",
+		"P",
+		"PT",
+		"P1DT",
+		"PT1H30X",
+		"1H",
+		"-PT1S",
+		"-10",
+		"PT1.2.3S",
+		"1e3",
+		"Inf",
+		"NaN",
+		"0x10",
+		"xPT1Hx",
+	} {
+		if secs, err := ParseDuration(dur); err == nil {
+			t.Errorf("%q: expected error, got %v", dur, secs)
+		}
+	}
+}
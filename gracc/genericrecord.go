@@ -0,0 +1,256 @@
+package gracc
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// genericField is like field, but keeps all attributes.
+type genericField struct {
+	XMLName xml.Name
+	Value   string     `xml:",chardata"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+}
+
+// flatten flattens the element as field.flatten does, with any
+// other attributes included as `<element>_<attribute>`.
+func (g *genericField) flatten() map[string]interface{} {
+	var r = make(map[string]interface{})
+	f := field{XMLName: g.XMLName, Value: g.Value}
+	if strings.TrimSpace(f.Value) == "" {
+		// probably an element with children
+		f.Value = ""
+	}
+	for _, a := range g.Attrs {
+		switch a.Name.Local {
+		case "description":
+			f.Description = a.Value
+		case "unit":
+			f.Unit = a.Value
+		case "phaseUnit":
+			f.PhaseUnit = a.Value
+		case "storageUnit":
+			f.StorageUnit = a.Value
+		case "formula":
+			f.Formula = a.Value
+		case "metric":
+			f.Metric = a.Value
+		default:
+			if a.Name.Space != "xmlns" && a.Name.Local != "xmlns" {
+				r[g.XMLName.Local+"_"+a.Name.Local] = a.Value
+			}
+		}
+	}
+	for k, v := range f.flatten() {
+		r[k] = v
+	}
+	return r
+}
+
+func (g *genericField) name() xml.Name {
+	return g.XMLName
+}
+
+func (g *genericField) label() string {
+	var desc string
+	for _, a := range g.Attrs {
+		switch a.Name.Local {
+		case "metric":
+			return a.Value
+		case "description":
+			desc = a.Value
+		}
+	}
+	return desc
+}
+
+func (g *genericField) flattenAs(key string) map[string]interface{} {
+	gg := *g
+	gg.XMLName.Local = key
+	return gg.flatten()
+}
+
+// genericFieldList is a list of elements flattened as genericFields.
+type genericFieldList []genericField
+
+func (gl genericFieldList) flatten() map[string]interface{} {
+	ff := make([]flatField, len(gl))
+	for i := range gl {
+		ff[i] = &gl[i]
+	}
+	return flattenFields(ff)
+}
+
+func (gl genericFieldList) durations() []namedDuration {
+	var d []namedDuration
+	for _, f := range gl {
+		for _, a := range f.Attrs {
+			if a.Name.Local == "phaseUnit" {
+				d = append(d, namedDuration{fieldKey(f.XMLName) + "_phaseUnit", a.Value})
+			}
+		}
+	}
+	return d
+}
+
+// GenericRecord is a container for records of a type that is not otherwise
+// recognized, so that they can be passed along anyway.
+type GenericRecord struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr       `xml:",any,attr"`
+	Origin  origin           `xml:",omitempty"`
+	Fields  genericFieldList `xml:",any"`
+	RawXML  []byte           `xml:",innerxml"`
+}
+
+// ParseGenericRecordXML unmarshals the XML in buf into a GenericRecord,
+// regardless of the record type.
+func ParseGenericRecordXML(buf []byte) (*GenericRecord, error) {
+	var gr GenericRecord
+	if err := gr.ParseXML(buf); err != nil {
+		return nil, err
+	}
+	return &gr, nil
+}
+
+// ParseXML attempts to unmarshal the XML in xb into a GenericRecord.
+func (gr *GenericRecord) ParseXML(xb []byte) error {
+	if err := xml.Unmarshal(xb, gr); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Id returns an identification string for the record, if one of the common
+// identifying elements (RecordIdentity, UniqueID, RecordId) is present.
+func (gr *GenericRecord) Id() string {
+	for _, f := range gr.Fields {
+		switch f.XMLName.Local {
+		case "UniqueID", "RecordId":
+			return f.Value
+		case "RecordIdentity":
+			for _, a := range f.Attrs {
+				if a.Name.Local == "recordId" {
+					return a.Value
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Type returns the type of the record.
+func (gr *GenericRecord) Type() string {
+	return gr.XMLName.Local
+}
+
+// Raw returns the unaltered source of the record. Unlike the known record
+// types, the root attributes and namespace declarations are kept, since
+// prefixed elements of unknown records are common.
+func (gr *GenericRecord) Raw() []byte {
+	buf, _ := rebuildRecordXML(gr.XMLName, gr.Attrs, gr.RawXML)
+	return buf.Bytes()
+}
+
+// ToURJSON returns a JSON encoding of the Record that keeps the
+// structure of the XML.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (gr *GenericRecord) ToURJSON(indent string) ([]byte, error) {
+	return toURJSON(gr.XMLName, gr.Attrs, gr.RawXML, indent)
+}
+
+// ToXML returns the record as canonical XML, with its namespaces
+// and extension elements.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (gr *GenericRecord) ToXML(indent string) ([]byte, error) {
+	return toURXML(gr.XMLName, gr.Attrs, gr.RawXML, indent)
+}
+
+func (gr *GenericRecord) durations() []namedDuration {
+	return gr.Fields.durations()
+}
+
+// ToJSON returns a best-effort JSON encoding of the Record: attributes of the
+// record element are included directly, and child elements are flattened the
+// same as other fields of known record types. Nested elements are not
+// flattened, but can be found in RawXML.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (gr *GenericRecord) ToJSON(indent string) ([]byte, error) {
+	var r = make(map[string]interface{})
+
+	for _, a := range gr.Attrs {
+		if a.Name.Space != "xmlns" && a.Name.Local != "xmlns" {
+			r[a.Name.Local] = a.Value
+		}
+	}
+
+	// flatten fields
+	for k, v := range gr.Fields.flatten() {
+		r[k] = v
+	}
+
+	// origin
+	for k, v := range gr.Origin.flatten() {
+		r[k] = v
+	}
+
+	r["type"] = gr.XMLName.Local
+
+	// warnings
+	addWarnings(r, gr)
+
+	// add XML
+	r["RawXML"] = string(gr.Raw())
+
+	if indent != "" {
+		return json.MarshalIndent(r, "", indent)
+	}
+	return json.Marshal(r)
+}
+
+// rebuildRecordXML rebuilds the record element from its name, root attributes
+// (including namespace declarations) and inner XML. It also returns the
+// prefixes declared on the record element, keyed by namespace URI.
+func rebuildRecordXML(name xml.Name, attrs []xml.Attr, inner []byte) (*bytes.Buffer, map[string]string) {
+	var prefixes = make(map[string]string)
+	var buf bytes.Buffer
+	buf.WriteString("<" + name.Local)
+	for _, a := range attrs {
+		switch {
+		case a.Name.Space == "xmlns":
+			prefixes[a.Value] = a.Name.Local
+			fmt.Fprintf(&buf, " xmlns:%s=\"", a.Name.Local)
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			buf.WriteString(" xmlns=\"")
+		default:
+			// attributes are written after all declarations are known
+			continue
+		}
+		xml.EscapeText(&buf, []byte(a.Value))
+		buf.WriteString("\"")
+	}
+	for _, a := range attrs {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		if p, ok := prefixes[a.Name.Space]; ok && a.Name.Space != "" {
+			fmt.Fprintf(&buf, " %s:%s=\"", p, a.Name.Local)
+		} else if a.Name.Space != "" && !strings.ContainsAny(a.Name.Space, ":/") {
+			fmt.Fprintf(&buf, " %s:%s=\"", a.Name.Space, a.Name.Local)
+		} else {
+			fmt.Fprintf(&buf, " %s=\"", a.Name.Local)
+		}
+		xml.EscapeText(&buf, []byte(a.Value))
+		buf.WriteString("\"")
+	}
+	buf.WriteString(">")
+	buf.Write(inner)
+	buf.WriteString("</" + name.Local + ">")
+	return &buf, prefixes
+}
@@ -0,0 +1,188 @@
+package gracc
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GPURule recognizes the GPU usage reported by probes of a type, which is
+// put in the GPUCount, GPUModel and GPUHours fields of JobUsageRecords.
+type GPURule struct {
+	// ProbeType is a shell pattern (see path.Match) matching the probe type,
+	// the part of the ProbeName before ":" (e.g. "condor" or "slurm"), or
+	// any probe if empty.
+	ProbeType string
+	// Count, Model and Hours are where the GPU count, model and hours are
+	// reported.
+	Count GPUField
+	Model GPUField
+	Hours GPUField
+}
+
+// GPUField is where a GPU value is reported.
+type GPUField struct {
+	// Keys is a comma-separated list of raw record keys, which may be
+	// shell patterns (e.g. "Resource_*GPU*"). The first key present is used;
+	// keys matching a pattern are tried in sorted order.
+	Keys string
+	// Regexp, if set, extracts the value from the first submatch (or the
+	// whole match, if it has no subexpressions), e.g. "gres/gpu=(\d+)".
+	// Values that don't match are ignored.
+	Regexp string
+	// Unit is the unit of Hours values that are plain numbers: "hours"
+	// (the default), "minutes", or "seconds". Durations (e.g. "PT2H") are
+	// always converted to hours.
+	Unit   string
+	keys   []string
+	regexp *regexp.Regexp
+}
+
+// gpuUnits are the seconds in each unit of GPU hours.
+var gpuUnits = map[string]float64{
+	"":        3600,
+	"hours":   3600,
+	"minutes": 60,
+	"seconds": 1,
+}
+
+// compile checks the field, and compiles its keys and regexp.
+func (f *GPUField) compile() error {
+	f.keys = nil
+	for _, k := range strings.Split(f.Keys, ",") {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		if _, err := path.Match(k, ""); err != nil {
+			return fmt.Errorf("bad key pattern %q", k)
+		}
+		f.keys = append(f.keys, k)
+	}
+	f.regexp = nil
+	if f.Regexp != "" {
+		var err error
+		if f.regexp, err = regexp.Compile(f.Regexp); err != nil {
+			return err
+		}
+	}
+	if _, ok := gpuUnits[f.Unit]; !ok {
+		return fmt.Errorf("unknown unit %q", f.Unit)
+	}
+	return nil
+}
+
+// compileGPURules checks and compiles rules, returning the compiled copy.
+func compileGPURules(rules []GPURule) ([]GPURule, error) {
+	if rules == nil {
+		return nil, nil
+	}
+	var c = make([]GPURule, len(rules))
+	copy(c, rules)
+	for i := range c {
+		if _, err := path.Match(c[i].ProbeType, ""); err != nil {
+			return nil, fmt.Errorf("GPU rule %d: bad probe type pattern %q", i+1, c[i].ProbeType)
+		}
+		for _, f := range []*GPUField{&c[i].Count, &c[i].Model, &c[i].Hours} {
+			if err := f.compile(); err != nil {
+				return nil, fmt.Errorf("GPU rule %d: %s", i+1, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// value returns the value of the field in raw record r, as a string.
+func (f *GPUField) value(r map[string]interface{}) (string, bool) {
+	for _, k := range f.keys {
+		var keys []string
+		if strings.ContainsAny(k, "*?[\\") {
+			for rk := range r {
+				if ok, _ := path.Match(k, rk); ok {
+					keys = append(keys, rk)
+				}
+			}
+			sort.Strings(keys)
+		} else {
+			keys = []string{k}
+		}
+		for _, rk := range keys {
+			v, ok := r[rk]
+			if !ok || v == nil {
+				continue
+			}
+			s := fmt.Sprint(v)
+			if f.regexp != nil {
+				m := f.regexp.FindStringSubmatch(s)
+				if m == nil {
+					continue
+				}
+				if s = m[0]; len(m) > 1 {
+					s = m[1]
+				}
+			}
+			if s = strings.TrimSpace(s); s != "" {
+				return s, true
+			}
+		}
+	}
+	return "", false
+}
+
+// addGPU sets the GPU fields of raw JobUsageRecord r from the first of the
+// configured rules for its probe type that gives each of them. Zero counts
+// and hours are ignored. If the GPU hours are not reported, but the count is,
+// GPUHours is the GPUCount times the WallDuration in hours, and
+// GPUHoursDerived is set.
+func addGPU(r map[string]interface{}) {
+	if len(options.GPU) == 0 {
+		return
+	}
+	probe, _ := r["ProbeName"].(string)
+	probeType := probe
+	if i := strings.Index(probe, ":"); i >= 0 {
+		probeType = probe[:i]
+	}
+	var (
+		count, hours       float64
+		model              string
+		haveCount, haveHrs bool
+	)
+	for i := range options.GPU {
+		g := &options.GPU[i]
+		if ok, _ := path.Match(g.ProbeType, probeType); g.ProbeType != "" && !ok {
+			continue
+		}
+		if s, ok := g.Count.value(r); ok && !haveCount {
+			if n, err := strconv.ParseFloat(s, 64); err == nil && n > 0 {
+				count, haveCount = n, true
+			}
+		}
+		if s, ok := g.Model.value(r); ok && model == "" {
+			model = s
+		}
+		if s, ok := g.Hours.value(r); ok && !haveHrs {
+			if n, err := strconv.ParseFloat(s, 64); err == nil {
+				hours = n * gpuUnits[g.Hours.Unit] / 3600
+			} else if secs, err := ParseDuration(s); err == nil {
+				hours = secs / 3600
+			}
+			haveHrs = hours > 0
+		}
+	}
+	if haveCount {
+		r["GPUCount"] = count
+	}
+	if model != "" {
+		r["GPUModel"] = model
+	}
+	if haveHrs {
+		r["GPUHours"] = hours
+	} else if haveCount {
+		wall, _ := Number(r["WallDuration"])
+		r["GPUHours"] = count * wall / 3600
+		r["GPUHoursDerived"] = true
+	}
+}
@@ -0,0 +1,103 @@
+package gracc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+var testGPURules = []GPURule{
+	{
+		ProbeType: "slurm",
+		Count:     GPUField{Keys: "Resource_AllocTRES", Regexp: `gres/gpu(?::[^=]*)?=(\d+)`},
+		Model:     GPUField{Keys: "Resource_GresModel"},
+	},
+	{
+		ProbeType: "condor",
+		Count:     GPUField{Keys: "Resource_GPUsProvisioned,Resource_RequestGPUs"},
+		Model:     GPUField{Keys: "Resource_GPUs_DeviceName"},
+		Hours:     GPUField{Keys: "Resource_GPUTime", Unit: "seconds"},
+	},
+	{
+		ProbeType: "pbs*",
+		Count:     GPUField{Keys: "Resource_*ngpus*"},
+	},
+}
+
+func TestGPU(t *testing.T) {
+	defer SetOptions(Options{})
+	if err := SetOptions(Options{GPU: testGPURules}); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile("test_data/JobUsageRecord10.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ParseRecordXML(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := v.ToJSON("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r map[string]interface{}
+	if err := json.Unmarshal(j, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r["GPUCount"] != 2.0 || r["GPUModel"] != "tesla_v100" || r["GPUHours"] != 4.0 || r["GPUHoursDerived"] != true {
+		t.Errorf("unexpected GPU fields: %v %v %v %v", r["GPUCount"], r["GPUModel"], r["GPUHours"], r["GPUHoursDerived"])
+	}
+
+	for _, tt := range []struct {
+		r      map[string]interface{}
+		expect map[string]interface{} // expected values; nil means absent
+	}{
+		{
+			map[string]interface{}{"ProbeName": "condor:ce.example.org", "WallDuration": 3600.0,
+				"Resource_RequestGPUs": "2", "Resource_GPUsProvisioned": "1",
+				"Resource_GPUs_DeviceName": "NVIDIA A100", "Resource_GPUTime": "5400"},
+			map[string]interface{}{"GPUCount": 1.0, "GPUModel": "NVIDIA A100", "GPUHours": 1.5, "GPUHoursDerived": nil},
+		},
+		{
+			map[string]interface{}{"ProbeName": "condor:ce.example.org", "WallDuration": 3600.0,
+				"Resource_RequestGPUs": "0", "Resource_GPUTime": "PT30M"},
+			map[string]interface{}{"GPUCount": nil, "GPUHours": 0.5},
+		},
+		{
+			map[string]interface{}{"ProbeName": "condor:ce.example.org", "WallDuration": 3600.0},
+			map[string]interface{}{"GPUCount": nil, "GPUHours": nil, "GPUModel": nil},
+		},
+		{
+			map[string]interface{}{"ProbeName": "pbs-lite:ce.example.org", "WallDuration": 1800.0,
+				"Resource_Resource_List.ngpus": 4.0},
+			map[string]interface{}{"GPUCount": 4.0, "GPUHours": 2.0, "GPUHoursDerived": true},
+		},
+		{
+			// rules are per probe type
+			map[string]interface{}{"ProbeName": "slurm:ce.example.org", "WallDuration": 1800.0,
+				"Resource_RequestGPUs": "2"},
+			map[string]interface{}{"GPUCount": nil},
+		},
+	} {
+		addGPU(tt.r)
+		for k, want := range tt.expect {
+			if got, ok := tt.r[k]; want == nil && ok {
+				t.Errorf("%v: unexpected %s %v", tt.r["ProbeName"], k, got)
+			} else if want != nil && got != want {
+				t.Errorf("%v: expected %s %v, got %v", tt.r["ProbeName"], k, want, got)
+			}
+		}
+	}
+
+	for _, bad := range []GPURule{
+		{ProbeType: "["},
+		{Count: GPUField{Keys: "Resource_["}},
+		{Count: GPUField{Keys: "Resource_GPUs", Regexp: "("}},
+		{Hours: GPUField{Keys: "Resource_GPUTime", Unit: "days"}},
+	} {
+		if err := SetOptions(Options{GPU: []GPURule{bad}}); err == nil {
+			t.Errorf("%+v: expected error", bad)
+		}
+	}
+}
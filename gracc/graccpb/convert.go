@@ -0,0 +1,314 @@
+// Package graccpb is the Protocol Buffers encoding of GRACC raw records.
+// The schema is in gracc.proto.
+package graccpb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	proto "github.com/golang/protobuf/proto"
+	"github.com/opensciencegrid/gracc-collector/gracc"
+)
+
+// The Go names of the typed fields of each message, by raw record field.
+var (
+	jobUsageRecordFields = map[string]string{
+		"RecordId":         "RecordId",
+		"CreateTime":       "CreateTime",
+		"GlobalJobId":      "GlobalJobId",
+		"LocalJobId":       "LocalJobId",
+		"GlobalUsername":   "GlobalUsername",
+		"LocalUserId":      "LocalUserId",
+		"VOName":           "VoName",
+		"ReportableVOName": "ReportableVoName",
+		"CommonName":       "CommonName",
+		"DN":               "Dn",
+		"JobName":          "JobName",
+		"MachineName":      "MachineName",
+		"SubmitHost":       "SubmitHost",
+		"Queue":            "Queue",
+		"ProjectName":      "ProjectName",
+		"Status":           "Status",
+		"StartTime":        "StartTime",
+		"EndTime":          "EndTime",
+		"WallDuration":     "WallDuration",
+		"CpuDuration":      "CpuDuration",
+		"Processors":       "Processors",
+		"NodeCount":        "NodeCount",
+		"Njobs":            "Njobs",
+		"ResourceType":     "ResourceType",
+		"Host":             "Host",
+		"ProbeName":        "ProbeName",
+		"SiteName":         "SiteName",
+		"Grid":             "Grid",
+	}
+	storageElementFields = map[string]string{
+		"UniqueID":       "UniqueId",
+		"SE":             "Se",
+		"Name":           "Name",
+		"SpaceType":      "SpaceType",
+		"Timestamp":      "Timestamp",
+		"Implementation": "Implementation",
+		"Version":        "Version",
+		"Status":         "Status",
+		"ProbeName":      "ProbeName",
+		"SiteName":       "SiteName",
+		"Grid":           "Grid",
+	}
+	storageElementRecordFields = map[string]string{
+		"UniqueID":        "UniqueId",
+		"MeasurementType": "MeasurementType",
+		"StorageType":     "StorageType",
+		"Timestamp":       "Timestamp",
+		"TotalSpace":      "TotalSpace",
+		"FreeSpace":       "FreeSpace",
+		"UsedSpace":       "UsedSpace",
+		"FileCount":       "FileCount",
+		"FileCountLimit":  "FileCountLimit",
+		"ProbeName":       "ProbeName",
+		"SiteName":        "SiteName",
+		"Grid":            "Grid",
+	}
+)
+
+// Marshal returns the protobuf encoding of rec as a Record message.
+func Marshal(rec gracc.Record) ([]byte, error) {
+	m, err := FromRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+// MarshalMap returns the protobuf encoding of the raw record r
+// as a Record message.
+func MarshalMap(r map[string]interface{}) ([]byte, error) {
+	m, err := FromMap(r)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+// FromRecord converts a record to a Record message, from its
+// raw record (JSON) encoding.
+func FromRecord(rec gracc.Record) (*Record, error) {
+	j, err := rec.ToJSON("")
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	var r map[string]interface{}
+	if err := d.Decode(&r); err != nil {
+		return nil, err
+	}
+	return FromMap(r)
+}
+
+// FromMap converts a raw record, as decoded from its JSON encoding (with or
+// without UseNumber), to a Record message.
+func FromMap(r map[string]interface{}) (*Record, error) {
+	var m Record
+	m.Type, _ = r["type"].(string)
+	var raw []byte
+	if x, ok := r["RawXML"].(string); ok {
+		raw = []byte(x)
+	}
+	var err error
+	switch m.Type {
+	case "JobUsageRecord":
+		m.JobUsageRecord = &JobUsageRecord{RawXml: raw}
+		m.JobUsageRecord.Extensions, err = setFields(m.JobUsageRecord, jobUsageRecordFields, r)
+	case "StorageElement":
+		m.StorageElement = &StorageElement{RawXml: raw}
+		m.StorageElement.Extensions, err = setFields(m.StorageElement, storageElementFields, r)
+	case "StorageElementRecord":
+		m.StorageElementRecord = &StorageElementRecord{RawXml: raw}
+		m.StorageElementRecord.Extensions, err = setFields(m.StorageElementRecord, storageElementRecordFields, r)
+	default:
+		m.Extensions, err = setFields(nil, nil, r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// setFields sets the typed fields of message m from r, returning
+// the other fields as extensions.
+func setFields(m interface{}, fields map[string]string, r map[string]interface{}) (map[string]*Value, error) {
+	var ext = make(map[string]*Value)
+	for k, v := range r {
+		if k == "type" || k == "RawXML" {
+			continue
+		}
+		if f, ok := fields[k]; ok && setField(reflect.ValueOf(m).Elem().FieldByName(f), v) {
+			continue
+		}
+		x, err := newValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding field %s: %s", k, err)
+		}
+		ext[k] = x
+	}
+	if len(ext) == 0 {
+		return nil, nil
+	}
+	return ext, nil
+}
+
+// setField sets the typed field f to v, if v is not the zero value and
+// can be converted. Numeric strings are converted to numbers, and numbers to
+// strings.
+func setField(f reflect.Value, v interface{}) bool {
+	switch f.Kind() {
+	case reflect.String:
+		switch x := v.(type) {
+		case string:
+			if x == "" {
+				return false
+			}
+			f.SetString(x)
+			return true
+		case float64:
+			f.SetString(strconv.FormatFloat(x, 'f', -1, 64))
+			return true
+		case json.Number:
+			f.SetString(x.String())
+			return true
+		}
+	case reflect.Float64, reflect.Int64, reflect.Uint64:
+		var n float64
+		switch x := v.(type) {
+		case float64:
+			n = x
+		case json.Number:
+			// exact conversion of large integers
+			if u, err := strconv.ParseUint(x.String(), 10, 64); err == nil && f.Kind() == reflect.Uint64 && u > 0 {
+				f.SetUint(u)
+				return true
+			}
+			if i, err := strconv.ParseInt(x.String(), 10, 64); err == nil && f.Kind() == reflect.Int64 && i != 0 {
+				f.SetInt(i)
+				return true
+			}
+			var err error
+			if n, err = x.Float64(); err != nil {
+				return false
+			}
+		case string:
+			var err error
+			if n, err = strconv.ParseFloat(x, 64); err != nil {
+				return false
+			}
+		default:
+			return false
+		}
+		if n == 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+			return false
+		}
+		switch f.Kind() {
+		case reflect.Float64:
+			f.SetFloat(n)
+		case reflect.Int64:
+			if n != math.Trunc(n) || math.Abs(n) > 1<<53 {
+				return false
+			}
+			f.SetInt(int64(n))
+		case reflect.Uint64:
+			if n != math.Trunc(n) || n < 0 || n > 1<<53 {
+				return false
+			}
+			f.SetUint(uint64(n))
+		}
+		return true
+	}
+	return false
+}
+
+func newValue(v interface{}) (*Value, error) {
+	switch x := v.(type) {
+	case string:
+		return &Value{Kind: Value_STRING, StringValue: x}, nil
+	case float64:
+		return &Value{Kind: Value_NUMBER, NumberValue: x}, nil
+	case json.Number:
+		if n, err := x.Float64(); err == nil {
+			return &Value{Kind: Value_NUMBER, NumberValue: n}, nil
+		}
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &Value{Kind: Value_JSON, JsonValue: string(j)}, nil
+}
+
+// Map returns the raw record held by the message, as it would be decoded from
+// JSON. Typed fields that are set are included, and numeric typed fields are
+// always numbers.
+func (m *Record) Map() (map[string]interface{}, error) {
+	var r = make(map[string]interface{})
+	r["type"] = m.Type
+	var (
+		msg    interface{}
+		fields map[string]string
+		ext    = m.Extensions
+		raw    []byte
+	)
+	switch {
+	case m.JobUsageRecord != nil:
+		msg, fields = m.JobUsageRecord, jobUsageRecordFields
+		ext, raw = m.JobUsageRecord.Extensions, m.JobUsageRecord.RawXml
+	case m.StorageElement != nil:
+		msg, fields = m.StorageElement, storageElementFields
+		ext, raw = m.StorageElement.Extensions, m.StorageElement.RawXml
+	case m.StorageElementRecord != nil:
+		msg, fields = m.StorageElementRecord, storageElementRecordFields
+		ext, raw = m.StorageElementRecord.Extensions, m.StorageElementRecord.RawXml
+	}
+	for k, name := range fields {
+		f := reflect.ValueOf(msg).Elem().FieldByName(name)
+		switch f.Kind() {
+		case reflect.String:
+			if f.String() != "" {
+				r[k] = f.String()
+			}
+		case reflect.Float64:
+			if f.Float() != 0 {
+				r[k] = f.Float()
+			}
+		case reflect.Int64:
+			if f.Int() != 0 {
+				r[k] = float64(f.Int())
+			}
+		case reflect.Uint64:
+			if f.Uint() != 0 {
+				r[k] = float64(f.Uint())
+			}
+		}
+	}
+	for k, v := range ext {
+		switch v.Kind {
+		case Value_STRING:
+			r[k] = v.StringValue
+		case Value_NUMBER:
+			r[k] = v.NumberValue
+		case Value_JSON:
+			var x interface{}
+			if err := json.Unmarshal([]byte(v.JsonValue), &x); err != nil {
+				return nil, fmt.Errorf("error decoding field %s: %s", k, err)
+			}
+			r[k] = x
+		}
+	}
+	if len(raw) > 0 {
+		r["RawXML"] = string(raw)
+	}
+	return r, nil
+}
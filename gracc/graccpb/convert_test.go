@@ -0,0 +1,287 @@
+package graccpb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	proto "github.com/golang/protobuf/proto"
+	"github.com/opensciencegrid/gracc-collector/gracc"
+)
+
+var Tests = []struct {
+	SourceXMLFile string
+	RefJSONFile   string
+	Message       string
+}{
+	{"../test_data/JobUsageRecord01.xml", "../test_data/JobUsageRecord01.json", "JobUsageRecord"},
+	{"../test_data/JobUsageRecord02.xml", "../test_data/JobUsageRecord02.json", "JobUsageRecord"},
+	{"../test_data/JobUsageRecord05.xml", "../test_data/JobUsageRecord05.json", "JobUsageRecord"},
+	{"../test_data/JobUsageRecord06.xml", "../test_data/JobUsageRecord06.json", "JobUsageRecord"},
+	{"../test_data/JobUsageRecord07.xml", "../test_data/JobUsageRecord07.json", "JobUsageRecord"},
+	{"../test_data/ComputeRecord01.xml", "../test_data/ComputeRecord01.json", "JobUsageRecord"},
+	{"../test_data/StorageElement01.xml", "../test_data/StorageElement01.json", "StorageElement"},
+	{"../test_data/StorageElementRecord01.xml", "../test_data/StorageElementRecord01.json", "StorageElementRecord"},
+	{"../test_data/StorageRecord01.xml", "../test_data/StorageRecord01.json", "StorageElementRecord"},
+	{"../test_data/ProbeDetails01.xml", "../test_data/ProbeDetails01.json", ""},
+}
+
+// sameValue compares a field value after conversion with its reference;
+// numeric strings may have been converted to numbers and vice versa.
+func sameValue(v, ref interface{}) bool {
+	if reflect.DeepEqual(v, ref) {
+		return true
+	}
+	switch r := ref.(type) {
+	case string:
+		if n, ok := v.(float64); ok {
+			f, err := strconv.ParseFloat(r, 64)
+			return err == nil && f == n
+		}
+	case float64:
+		if s, ok := v.(string); ok {
+			return s == strconv.FormatFloat(r, 'f', -1, 64)
+		}
+	}
+	return false
+}
+
+func TestProtobuf(t *testing.T) {
+	for _, pt := range Tests {
+		buf, err := ioutil.ReadFile(pt.SourceXMLFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := gracc.ParseRecordXML(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Marshal(rec)
+		if err != nil {
+			t.Fatalf("%s: %s", pt.SourceXMLFile, err)
+		}
+		var m Record
+		if err := proto.Unmarshal(b, &m); err != nil {
+			t.Fatalf("%s: %s", pt.SourceXMLFile, err)
+		}
+		switch pt.Message {
+		case "JobUsageRecord":
+			if m.JobUsageRecord == nil || m.JobUsageRecord.RecordId == "" {
+				t.Errorf("%s: JobUsageRecord not set: %s", pt.SourceXMLFile, &m)
+			}
+		case "StorageElement":
+			if m.StorageElement == nil || m.StorageElement.UniqueId == "" {
+				t.Errorf("%s: StorageElement not set: %s", pt.SourceXMLFile, &m)
+			}
+		case "StorageElementRecord":
+			if m.StorageElementRecord == nil || m.StorageElementRecord.UniqueId == "" {
+				t.Errorf("%s: StorageElementRecord not set: %s", pt.SourceXMLFile, &m)
+			}
+		default:
+			if m.JobUsageRecord != nil || m.StorageElement != nil || m.StorageElementRecord != nil || len(m.Extensions) == 0 {
+				t.Errorf("%s: expected only extensions: %s", pt.SourceXMLFile, &m)
+			}
+		}
+		if jur := m.GetJobUsageRecord(); jur != nil && string(jur.RawXml) != string(rec.Raw()) {
+			t.Errorf("%s: RawXML not kept", pt.SourceXMLFile)
+		}
+
+		r, err := m.Map()
+		if err != nil {
+			t.Fatal(err)
+		}
+		delete(r, "RawXML")
+		var direct map[string]interface{}
+		if j, err := rec.ToJSON(""); err != nil {
+			t.Fatal(err)
+		} else if err := json.Unmarshal(j, &direct); err != nil {
+			t.Fatal(err)
+		}
+		if len(r) != len(direct)-1 {
+			t.Errorf("%s: got %d fields, expected %d", pt.SourceXMLFile, len(r), len(direct)-1)
+		}
+		rbuf, err := ioutil.ReadFile(pt.RefJSONFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rref map[string]interface{}
+		if err := json.Unmarshal(rbuf, &rref); err != nil {
+			t.Fatal(err)
+		}
+		delete(rref, "RawXML")
+		for k, v := range r {
+			if !sameValue(v, rref[k]) {
+				t.Errorf("%s: '%s' Expected: '%v' Got '%v'", pt.SourceXMLFile, k, rref[k], v)
+			}
+		}
+	}
+}
+
+// protoField is a field declared in gracc.proto.
+type protoField struct {
+	typ, key, val string
+	num           int
+}
+
+// parseProto returns the fields of each message, and the values of each enum,
+// declared in gracc.proto, by their Go names (e.g. "Value_Kind").
+func parseProto(t *testing.T) (map[string]map[string]protoField, map[string]map[string]int32) {
+	buf, err := ioutil.ReadFile("gracc.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		blockRe = regexp.MustCompile(`^(message|enum)\s+(\w+)\s*{$`)
+		fieldRe = regexp.MustCompile(`^(?:map<(\w+),\s*(\w+)>|(\w+))\s+(\w+)\s*=\s*(\d+);$`)
+		valueRe = regexp.MustCompile(`^(\w+)\s*=\s*(\d+);$`)
+	)
+	messages := make(map[string]map[string]protoField)
+	enums := make(map[string]map[string]int32)
+	var stack []string
+	for _, line := range strings.Split(string(buf), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		var name string
+		if len(stack) > 0 {
+			name = strings.Join(stack, "_")
+		}
+		switch {
+		case line == "":
+		case line == "}":
+			stack = stack[:len(stack)-1]
+		case blockRe.MatchString(line):
+			m := blockRe.FindStringSubmatch(line)
+			stack = append(stack, m[2])
+			if m[1] == "message" {
+				messages[strings.Join(stack, "_")] = make(map[string]protoField)
+			} else {
+				enums[strings.Join(stack, "_")] = make(map[string]int32)
+			}
+		case enums[name] != nil && valueRe.MatchString(line):
+			m := valueRe.FindStringSubmatch(line)
+			n, _ := strconv.Atoi(m[2])
+			enums[name][m[1]] = int32(n)
+		case messages[name] != nil && fieldRe.MatchString(line):
+			m := fieldRe.FindStringSubmatch(line)
+			n, _ := strconv.Atoi(m[5])
+			f := protoField{typ: m[3], num: n}
+			if f.typ == "" {
+				f = protoField{typ: "map", key: m[1], val: m[2], num: n}
+			}
+			messages[name][m[4]] = f
+		}
+	}
+	if len(stack) != 0 {
+		t.Fatalf("gracc.proto: unclosed block %s", strings.Join(stack, "."))
+	}
+	return messages, enums
+}
+
+// protoWireTypes are the wire types in the protobuf struct tags of scalar
+// types, and of the Go types in the structs.
+var protoWireTypes = map[string]struct {
+	wire string
+	kind reflect.Kind
+}{
+	"string": {"bytes", reflect.String},
+	"bytes":  {"bytes", reflect.Slice},
+	"double": {"fixed64", reflect.Float64},
+	"float":  {"fixed32", reflect.Float32},
+	"bool":   {"varint", reflect.Bool},
+	"int32":  {"varint", reflect.Int32},
+	"int64":  {"varint", reflect.Int64},
+	"uint32": {"varint", reflect.Uint32},
+	"uint64": {"varint", reflect.Uint64},
+}
+
+// protoTag returns the wire type, number and options of protobuf struct tag.
+func protoTag(tag string) (string, int, map[string]string) {
+	parts := strings.Split(tag, ",")
+	opts := make(map[string]string)
+	for _, p := range parts[2:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 {
+			opts[kv[0]] = kv[1]
+		} else {
+			opts[kv[0]] = ""
+		}
+	}
+	n, _ := strconv.Atoi(parts[1])
+	return parts[0], n, opts
+}
+
+// TestProtoSchema checks the hand-maintained types in gracc.pb.go against
+// the messages and enums declared in gracc.proto.
+func TestProtoSchema(t *testing.T) {
+	messages, enums := parseProto(t)
+	for name, values := range enums {
+		if got := proto.EnumValueMap("gracc." + name); !reflect.DeepEqual(got, values) {
+			t.Errorf("enum %s: got values %v, expected %v", name, got, values)
+		}
+	}
+	for name, fields := range messages {
+		mt := proto.MessageType("gracc." + name)
+		if mt == nil {
+			t.Errorf("message %s is not registered", name)
+			continue
+		}
+		st := mt.Elem()
+		seen := make(map[string]bool)
+		for i := 0; i < st.NumField(); i++ {
+			sf := st.Field(i)
+			tag := sf.Tag.Get("protobuf")
+			if tag == "" {
+				continue
+			}
+			wire, num, opts := protoTag(tag)
+			fn := opts["name"]
+			seen[fn] = true
+			f, ok := fields[fn]
+			if !ok {
+				t.Errorf("%s.%s: field %s is not in gracc.proto", name, sf.Name, fn)
+				continue
+			}
+			if num != f.num {
+				t.Errorf("%s.%s: field number %d, expected %d", name, sf.Name, num, f.num)
+			}
+			_, repeated := opts["rep"]
+			if repeated != (f.typ == "map") {
+				t.Errorf("%s.%s: repeated is %v, expected %v", name, sf.Name, repeated, !repeated)
+			}
+			switch w, scalar := protoWireTypes[f.typ]; {
+			case scalar:
+				if wire != w.wire || sf.Type.Kind() != w.kind {
+					t.Errorf("%s.%s: %s %s, expected %s %s for %s", name, sf.Name, wire, sf.Type.Kind(), w.wire, w.kind, f.typ)
+				}
+			case f.typ == "map":
+				if sf.Type.Kind() != reflect.Map || sf.Type.Key().Kind() != protoWireTypes[f.key].kind ||
+					sf.Type.Elem() != proto.MessageType("gracc."+f.val) {
+					t.Errorf("%s.%s: type %s, expected map<%s, %s>", name, sf.Name, sf.Type, f.key, f.val)
+				}
+				if sf.Tag.Get("protobuf_key") != protoWireTypes[f.key].wire+",1,opt,name=key" ||
+					sf.Tag.Get("protobuf_val") != "bytes,2,opt,name=value" {
+					t.Errorf("%s.%s: wrong map key or value tags", name, sf.Name)
+				}
+			case enums[name+"_"+f.typ] != nil:
+				if wire != "varint" || opts["enum"] != "gracc."+name+"_"+f.typ || sf.Type.Kind() != reflect.Int32 {
+					t.Errorf("%s.%s: %s %s enum=%s, expected enum %s", name, sf.Name, wire, sf.Type, opts["enum"], f.typ)
+				}
+			default:
+				if wire != "bytes" || sf.Type != proto.MessageType("gracc."+f.typ) {
+					t.Errorf("%s.%s: %s %s, expected message %s", name, sf.Name, wire, sf.Type, f.typ)
+				}
+			}
+		}
+		for fn := range fields {
+			if !seen[fn] {
+				t.Errorf("%s: field %s of gracc.proto is missing", name, fn)
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Go types for the messages in gracc.proto.
+//
+// These are maintained by hand in the form generated by protoc-gen-go, for use
+// with the reflection-based github.com/golang/protobuf/proto package.
+// TestProtoSchema checks their field numbers and tags against gracc.proto.
+
+package graccpb
+
+import proto "github.com/golang/protobuf/proto"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+
+type Record struct {
+	Type                 string                `protobuf:"bytes,1,opt,name=type" json:"type,omitempty"`
+	JobUsageRecord       *JobUsageRecord       `protobuf:"bytes,2,opt,name=job_usage_record,json=jobUsageRecord" json:"job_usage_record,omitempty"`
+	StorageElement       *StorageElement       `protobuf:"bytes,3,opt,name=storage_element,json=storageElement" json:"storage_element,omitempty"`
+	StorageElementRecord *StorageElementRecord `protobuf:"bytes,4,opt,name=storage_element_record,json=storageElementRecord" json:"storage_element_record,omitempty"`
+	Extensions           map[string]*Value     `protobuf:"bytes,15,rep,name=extensions" json:"extensions,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *Record) Reset()         { *m = Record{} }
+func (m *Record) String() string { return proto.CompactTextString(m) }
+func (*Record) ProtoMessage()    {}
+
+func (m *Record) GetJobUsageRecord() *JobUsageRecord {
+	if m != nil {
+		return m.JobUsageRecord
+	}
+	return nil
+}
+
+func (m *Record) GetStorageElement() *StorageElement {
+	if m != nil {
+		return m.StorageElement
+	}
+	return nil
+}
+
+func (m *Record) GetStorageElementRecord() *StorageElementRecord {
+	if m != nil {
+		return m.StorageElementRecord
+	}
+	return nil
+}
+
+func (m *Record) GetExtensions() map[string]*Value {
+	if m != nil {
+		return m.Extensions
+	}
+	return nil
+}
+
+type Value_Kind int32
+
+const (
+	Value_STRING Value_Kind = 0
+	Value_NUMBER Value_Kind = 1
+	Value_JSON   Value_Kind = 2
+)
+
+var Value_Kind_name = map[int32]string{
+	0: "STRING",
+	1: "NUMBER",
+	2: "JSON",
+}
+var Value_Kind_value = map[string]int32{
+	"STRING": 0,
+	"NUMBER": 1,
+	"JSON":   2,
+}
+
+func (x Value_Kind) String() string {
+	return proto.EnumName(Value_Kind_name, int32(x))
+}
+
+type Value struct {
+	Kind        Value_Kind `protobuf:"varint,1,opt,name=kind,enum=gracc.Value_Kind" json:"kind,omitempty"`
+	StringValue string     `protobuf:"bytes,2,opt,name=string_value,json=stringValue" json:"string_value,omitempty"`
+	NumberValue float64    `protobuf:"fixed64,3,opt,name=number_value,json=numberValue" json:"number_value,omitempty"`
+	JsonValue   string     `protobuf:"bytes,4,opt,name=json_value,json=jsonValue" json:"json_value,omitempty"`
+}
+
+func (m *Value) Reset()         { *m = Value{} }
+func (m *Value) String() string { return proto.CompactTextString(m) }
+func (*Value) ProtoMessage()    {}
+
+type JobUsageRecord struct {
+	RecordId         string            `protobuf:"bytes,1,opt,name=record_id,json=recordId" json:"record_id,omitempty"`
+	CreateTime       string            `protobuf:"bytes,2,opt,name=create_time,json=createTime" json:"create_time,omitempty"`
+	GlobalJobId      string            `protobuf:"bytes,3,opt,name=global_job_id,json=globalJobId" json:"global_job_id,omitempty"`
+	LocalJobId       string            `protobuf:"bytes,4,opt,name=local_job_id,json=localJobId" json:"local_job_id,omitempty"`
+	GlobalUsername   string            `protobuf:"bytes,5,opt,name=global_username,json=globalUsername" json:"global_username,omitempty"`
+	LocalUserId      string            `protobuf:"bytes,6,opt,name=local_user_id,json=localUserId" json:"local_user_id,omitempty"`
+	VoName           string            `protobuf:"bytes,7,opt,name=vo_name,json=voName" json:"vo_name,omitempty"`
+	ReportableVoName string            `protobuf:"bytes,8,opt,name=reportable_vo_name,json=reportableVoName" json:"reportable_vo_name,omitempty"`
+	CommonName       string            `protobuf:"bytes,9,opt,name=common_name,json=commonName" json:"common_name,omitempty"`
+	Dn               string            `protobuf:"bytes,10,opt,name=dn" json:"dn,omitempty"`
+	JobName          string            `protobuf:"bytes,11,opt,name=job_name,json=jobName" json:"job_name,omitempty"`
+	MachineName      string            `protobuf:"bytes,12,opt,name=machine_name,json=machineName" json:"machine_name,omitempty"`
+	SubmitHost       string            `protobuf:"bytes,13,opt,name=submit_host,json=submitHost" json:"submit_host,omitempty"`
+	Queue            string            `protobuf:"bytes,14,opt,name=queue" json:"queue,omitempty"`
+	ProjectName      string            `protobuf:"bytes,15,opt,name=project_name,json=projectName" json:"project_name,omitempty"`
+	Status           string            `protobuf:"bytes,16,opt,name=status" json:"status,omitempty"`
+	StartTime        string            `protobuf:"bytes,17,opt,name=start_time,json=startTime" json:"start_time,omitempty"`
+	EndTime          string            `protobuf:"bytes,18,opt,name=end_time,json=endTime" json:"end_time,omitempty"`
+	WallDuration     float64           `protobuf:"fixed64,19,opt,name=wall_duration,json=wallDuration" json:"wall_duration,omitempty"`
+	CpuDuration      float64           `protobuf:"fixed64,20,opt,name=cpu_duration,json=cpuDuration" json:"cpu_duration,omitempty"`
+	Processors       int64             `protobuf:"varint,21,opt,name=processors" json:"processors,omitempty"`
+	NodeCount        int64             `protobuf:"varint,22,opt,name=node_count,json=nodeCount" json:"node_count,omitempty"`
+	Njobs            int64             `protobuf:"varint,23,opt,name=njobs" json:"njobs,omitempty"`
+	ResourceType     string            `protobuf:"bytes,24,opt,name=resource_type,json=resourceType" json:"resource_type,omitempty"`
+	Host             string            `protobuf:"bytes,25,opt,name=host" json:"host,omitempty"`
+	ProbeName        string            `protobuf:"bytes,26,opt,name=probe_name,json=probeName" json:"probe_name,omitempty"`
+	SiteName         string            `protobuf:"bytes,27,opt,name=site_name,json=siteName" json:"site_name,omitempty"`
+	Grid             string            `protobuf:"bytes,28,opt,name=grid" json:"grid,omitempty"`
+	Extensions       map[string]*Value `protobuf:"bytes,100,rep,name=extensions" json:"extensions,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	RawXml           []byte            `protobuf:"bytes,101,opt,name=raw_xml,json=rawXml,proto3" json:"raw_xml,omitempty"`
+}
+
+func (m *JobUsageRecord) Reset()         { *m = JobUsageRecord{} }
+func (m *JobUsageRecord) String() string { return proto.CompactTextString(m) }
+func (*JobUsageRecord) ProtoMessage()    {}
+
+func (m *JobUsageRecord) GetExtensions() map[string]*Value {
+	if m != nil {
+		return m.Extensions
+	}
+	return nil
+}
+
+type StorageElement struct {
+	UniqueId       string            `protobuf:"bytes,1,opt,name=unique_id,json=uniqueId" json:"unique_id,omitempty"`
+	Se             string            `protobuf:"bytes,2,opt,name=se" json:"se,omitempty"`
+	Name           string            `protobuf:"bytes,3,opt,name=name" json:"name,omitempty"`
+	SpaceType      string            `protobuf:"bytes,4,opt,name=space_type,json=spaceType" json:"space_type,omitempty"`
+	Timestamp      string            `protobuf:"bytes,5,opt,name=timestamp" json:"timestamp,omitempty"`
+	Implementation string            `protobuf:"bytes,6,opt,name=implementation" json:"implementation,omitempty"`
+	Version        string            `protobuf:"bytes,7,opt,name=version" json:"version,omitempty"`
+	Status         string            `protobuf:"bytes,8,opt,name=status" json:"status,omitempty"`
+	ProbeName      string            `protobuf:"bytes,9,opt,name=probe_name,json=probeName" json:"probe_name,omitempty"`
+	SiteName       string            `protobuf:"bytes,10,opt,name=site_name,json=siteName" json:"site_name,omitempty"`
+	Grid           string            `protobuf:"bytes,11,opt,name=grid" json:"grid,omitempty"`
+	Extensions     map[string]*Value `protobuf:"bytes,100,rep,name=extensions" json:"extensions,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	RawXml         []byte            `protobuf:"bytes,101,opt,name=raw_xml,json=rawXml,proto3" json:"raw_xml,omitempty"`
+}
+
+func (m *StorageElement) Reset()         { *m = StorageElement{} }
+func (m *StorageElement) String() string { return proto.CompactTextString(m) }
+func (*StorageElement) ProtoMessage()    {}
+
+func (m *StorageElement) GetExtensions() map[string]*Value {
+	if m != nil {
+		return m.Extensions
+	}
+	return nil
+}
+
+type StorageElementRecord struct {
+	UniqueId        string            `protobuf:"bytes,1,opt,name=unique_id,json=uniqueId" json:"unique_id,omitempty"`
+	MeasurementType string            `protobuf:"bytes,2,opt,name=measurement_type,json=measurementType" json:"measurement_type,omitempty"`
+	StorageType     string            `protobuf:"bytes,3,opt,name=storage_type,json=storageType" json:"storage_type,omitempty"`
+	Timestamp       string            `protobuf:"bytes,4,opt,name=timestamp" json:"timestamp,omitempty"`
+	TotalSpace      uint64            `protobuf:"varint,5,opt,name=total_space,json=totalSpace" json:"total_space,omitempty"`
+	FreeSpace       uint64            `protobuf:"varint,6,opt,name=free_space,json=freeSpace" json:"free_space,omitempty"`
+	UsedSpace       uint64            `protobuf:"varint,7,opt,name=used_space,json=usedSpace" json:"used_space,omitempty"`
+	FileCount       uint64            `protobuf:"varint,8,opt,name=file_count,json=fileCount" json:"file_count,omitempty"`
+	FileCountLimit  uint64            `protobuf:"varint,9,opt,name=file_count_limit,json=fileCountLimit" json:"file_count_limit,omitempty"`
+	ProbeName       string            `protobuf:"bytes,10,opt,name=probe_name,json=probeName" json:"probe_name,omitempty"`
+	SiteName        string            `protobuf:"bytes,11,opt,name=site_name,json=siteName" json:"site_name,omitempty"`
+	Grid            string            `protobuf:"bytes,12,opt,name=grid" json:"grid,omitempty"`
+	Extensions      map[string]*Value `protobuf:"bytes,100,rep,name=extensions" json:"extensions,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	RawXml          []byte            `protobuf:"bytes,101,opt,name=raw_xml,json=rawXml,proto3" json:"raw_xml,omitempty"`
+}
+
+func (m *StorageElementRecord) Reset()         { *m = StorageElementRecord{} }
+func (m *StorageElementRecord) String() string { return proto.CompactTextString(m) }
+func (*StorageElementRecord) ProtoMessage()    {}
+
+func (m *StorageElementRecord) GetExtensions() map[string]*Value {
+	if m != nil {
+		return m.Extensions
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*Record)(nil), "gracc.Record")
+	proto.RegisterType((*Value)(nil), "gracc.Value")
+	proto.RegisterType((*JobUsageRecord)(nil), "gracc.JobUsageRecord")
+	proto.RegisterType((*StorageElement)(nil), "gracc.StorageElement")
+	proto.RegisterType((*StorageElementRecord)(nil), "gracc.StorageElementRecord")
+	proto.RegisterEnum("gracc.Value_Kind", Value_Kind_name, Value_Kind_value)
+}
@@ -118,12 +118,14 @@ func (r *resource) flatten() map[string]interface{} {
 	if r.StorageUnit != "" {
 		rr[k+"_storageUnit"] = r.StorageUnit
 	}
+	typeValue(rr, k, r.StorageUnit)
 	return rr
 }
 
 // JobUsageRecord is a flexible container for batch job accounting records.
 type JobUsageRecord struct {
 	XMLName            xml.Name
+	Attrs              rootAttrs      `xml:",any,attr"`
 	RecordIdentity     recordIdentity `xml:",omitempty"`
 	JobIdentity        jobIdentity    `xml:",omitempty"`
 	UserIdentity       userIdentity   `xml:",omitempty"`
@@ -138,7 +140,7 @@ type JobUsageRecord struct {
 	PhaseResource      []resource     `xml:",omitempty"`
 	VolumeResource     []resource     `xml:",omitempty"`
 	Origin             origin         `xml:",omitempty"`
-	Fields             []field        `xml:",any"`
+	Fields             fieldList      `xml:",any"`
 	RawXML             []byte         `xml:",innerxml"`
 }
 
@@ -155,9 +157,10 @@ func (jur *JobUsageRecord) Id() string {
 	return jur.RecordIdentity.RecordId
 }
 
-// Type returns the type of the record.
+// Type returns the type of the record, which is JobUsageRecord for
+// UsageRecords too.
 func (jur *JobUsageRecord) Type() string {
-	return jur.XMLName.Local
+	return "JobUsageRecord"
 }
 
 // Raw returns the unaltered source of the record.
@@ -166,6 +169,48 @@ func (jur *JobUsageRecord) Raw() []byte {
 	return []byte(s)
 }
 
+// ToURJSON returns a JSON encoding of the Record that keeps the
+// structure of the Usage Record XML.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (jur *JobUsageRecord) ToURJSON(indent string) ([]byte, error) {
+	return toURJSON(jur.XMLName, jur.Attrs, jur.RawXML, indent)
+}
+
+// ToXML returns the record as canonical Usage Record XML, with its
+// namespaces and extension elements, or a *StructureError if it is not a
+// valid Usage Record.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (jur *JobUsageRecord) ToXML(indent string) ([]byte, error) {
+	return toURXML(jur.XMLName, jur.Attrs, jur.RawXML, indent)
+}
+
+func (jur *JobUsageRecord) durations() []namedDuration {
+	d := []namedDuration{{"WallDuration", jur.WallDuration.Value}}
+	for _, c := range jur.CpuDuration {
+		d = append(d, namedDuration{"CpuDuration", c.Value})
+	}
+	if len(jur.CpuDuration) == 0 {
+		d = append(d, namedDuration{"CpuDuration", ""})
+	}
+	for _, td := range jur.TimeDuration {
+		d = append(d, namedDuration{"TimeDuration", td.Value})
+	}
+	for _, resa := range [][]resource{jur.Resource,
+		jur.ConsumableResource,
+		jur.PhaseResource,
+		jur.VolumeResource,
+	} {
+		for _, res := range resa {
+			if res.PhaseUnit != "" {
+				d = append(d, namedDuration{"Resource_phaseUnit", res.PhaseUnit})
+			}
+		}
+	}
+	return append(d, jur.Fields.durations()...)
+}
+
 // ToJSON returns a JSON encoding of the Record, with certain elements
 // transformed to fit the GRACC Raw Record schema.
 // Indent specifies the string to use for each indentation level,
@@ -228,16 +273,11 @@ func (jur *JobUsageRecord) ToJSON(indent string) ([]byte, error) {
 			}
 		}
 	}
-	// Rename/Add ResourceType
-	switch r["Resource_ResourceType"] {
-	case nil, "", "Batch":
-		r["ResourceType"] = "Batch"
-	case "BatchPilot":
-		r["ResourceType"] = "Payload"
-	default:
-		r["ResourceType"] = r["Resource_ResourceType"]
+	// ResourceType is moved to the top level, and classified below
+	if rt, ok := r["Resource_ResourceType"]; ok {
+		r["ResourceType"] = rt
+		delete(r, "Resource_ResourceType")
 	}
-	delete(r, "Resource_ResourceType")
 
 	// time durations and instants
 	for _, td := range jur.TimeDuration {
@@ -252,10 +292,8 @@ func (jur *JobUsageRecord) ToJSON(indent string) ([]byte, error) {
 	}
 
 	// flatten other fields
-	for _, f := range jur.Fields {
-		for k, v := range f.flatten() {
-			r[k] = v
-		}
+	for k, v := range jur.Fields.flatten() {
+		r[k] = v
 	}
 
 	// origin
@@ -263,6 +301,20 @@ func (jur *JobUsageRecord) ToJSON(indent string) ([]byte, error) {
 		r[k] = v
 	}
 
+	// ResourceType and other classification
+	classify(r)
+
+	// GPU usage
+	addGPU(r)
+
+	// derived usage
+	if options.Derived {
+		addDerived(r)
+	}
+
+	// warnings
+	addWarnings(r, jur)
+
 	// add XML
 	r["RawXML"] = string(jur.Raw())
 
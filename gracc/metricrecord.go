@@ -0,0 +1,103 @@
+package gracc
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+)
+
+// MetricRecord is a flexible container for the result of a service probe
+// (e.g. RSV) test.
+type MetricRecord struct {
+	XMLName        xml.Name
+	Attrs          rootAttrs      `xml:",any,attr"`
+	RecordIdentity recordIdentity `xml:",omitempty"`
+	Timestamp      time.Time      `xml:",omitempty"`
+	Origin         origin         `xml:",omitempty"`
+	Fields         fieldList      `xml:",any"`
+	RawXML         []byte         `xml:",innerxml"`
+}
+
+// ParseXML attempts to unmarshal the XML in xb into a MetricRecord.
+func (mr *MetricRecord) ParseXML(xb []byte) error {
+	if err := xml.Unmarshal(xb, mr); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Id returns an identification string for the record.
+func (mr *MetricRecord) Id() string {
+	return mr.RecordIdentity.RecordId
+}
+
+// Type returns the type of the record.
+func (mr *MetricRecord) Type() string {
+	return mr.XMLName.Local
+}
+
+// Raw returns the unaltered source of the record.
+func (mr *MetricRecord) Raw() []byte {
+	s := "<" + mr.XMLName.Local + ">" + string(mr.RawXML) + "</" + mr.XMLName.Local + ">"
+	return []byte(s)
+}
+
+// ToURJSON returns a JSON encoding of the Record that keeps the
+// structure of the Usage Record XML.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (mr *MetricRecord) ToURJSON(indent string) ([]byte, error) {
+	return toURJSON(mr.XMLName, mr.Attrs, mr.RawXML, indent)
+}
+
+// ToXML returns the record as canonical XML, with its namespaces
+// and extension elements.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (mr *MetricRecord) ToXML(indent string) ([]byte, error) {
+	return toURXML(mr.XMLName, mr.Attrs, mr.RawXML, indent)
+}
+
+func (mr *MetricRecord) durations() []namedDuration {
+	return mr.Fields.durations()
+}
+
+// ToJSON returns a JSON encoding of the Record, with certain elements
+// transformed to fit the GRACC Raw Record schema.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (mr *MetricRecord) ToJSON(indent string) ([]byte, error) {
+	var r = make(map[string]interface{})
+
+	r["type"] = "MetricRecord"
+
+	for k, v := range mr.RecordIdentity.flatten() {
+		r[k] = v
+	}
+
+	// Standard time instants
+	if !mr.Timestamp.IsZero() {
+		r["Timestamp"] = mr.Timestamp.Format(time.RFC3339)
+	}
+
+	// flatten other fields
+	for k, v := range mr.Fields.flatten() {
+		r[k] = v
+	}
+
+	// origin
+	for k, v := range mr.Origin.flatten() {
+		r[k] = v
+	}
+
+	// warnings
+	addWarnings(r, mr)
+
+	// add XML
+	r["RawXML"] = string(mr.Raw())
+
+	if indent != "" {
+		return json.MarshalIndent(r, "", indent)
+	}
+	return json.Marshal(r)
+}
@@ -0,0 +1,79 @@
+package gracc
+
+import (
+	"fmt"
+)
+
+// Ways to handle elements that are repeated in a record.
+const (
+	// RepeatedOverwrite keeps only the last of the repeated elements.
+	RepeatedOverwrite = "overwrite"
+	// RepeatedArray puts the values of all repeated elements in arrays.
+	RepeatedArray = "array"
+	// RepeatedSuffix keeps the first element as is, and suffixes the keys of
+	// the others with their metric or description (or position, if neither is
+	// set or the key would still collide).
+	RepeatedSuffix = "suffix"
+)
+
+// Options control how records are converted to JSON.
+type Options struct {
+	// Repeated is how repeated elements are handled, one of
+	// RepeatedOverwrite (the default), RepeatedArray, or RepeatedSuffix.
+	Repeated string
+	// Namespaces maps namespace URIs to the prefix used to qualify the keys
+	// of elements in that namespace. Elements in the Usage Record namespaces
+	// are not qualified; elements in other namespaces not in the map are
+	// qualified with the namespace URI.
+	Namespaces map[string]string
+	// NumericValues converts the values of count and size elements
+	// (Processors, NodeCount, Njobs, Memory, Swap, Disk, Network, Status)
+	// and of JobUsageRecord Resources to numbers, where they parse as such.
+	NumericValues bool
+	// NormalizeUnits converts values with a storageUnit to bytes.
+	NormalizeUnits bool
+	// Durations is how invalid durations are handled, one of
+	// DurationsLenient (the default) or DurationsStrict.
+	Durations string
+	// Classify are the rules that set ResourceType and other classification
+	// fields of JobUsageRecords. If nil, DefaultClassifyRules are used.
+	Classify []ClassifyRule
+	// Derived adds the fields derived from the usage of JobUsageRecords
+	// (Cores, CoresSource, CoreHours and CpuEfficiency).
+	Derived bool
+	// GPU are the rules that recognize the GPU usage of JobUsageRecords.
+	GPU []GPURule
+}
+
+var options = Options{
+	Repeated:  RepeatedOverwrite,
+	Durations: DurationsLenient,
+}
+
+// SetOptions sets the options used for all subsequent conversions.
+// It is not safe to call while records are being converted.
+func SetOptions(o Options) error {
+	switch o.Repeated {
+	case "":
+		o.Repeated = RepeatedOverwrite
+	case RepeatedOverwrite, RepeatedArray, RepeatedSuffix:
+	default:
+		return fmt.Errorf("unknown repeated field handling %q", o.Repeated)
+	}
+	switch o.Durations {
+	case "":
+		o.Durations = DurationsLenient
+	case DurationsLenient, DurationsStrict:
+	default:
+		return fmt.Errorf("unknown duration handling %q", o.Durations)
+	}
+	if err := checkClassifyRules(o.Classify); err != nil {
+		return err
+	}
+	var err error
+	if o.GPU, err = compileGPURules(o.GPU); err != nil {
+		return err
+	}
+	options = o
+	return nil
+}
@@ -0,0 +1,153 @@
+package gracc
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+)
+
+// software is the name and version of a software component.
+type software struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+	Version string `xml:"version,attr,omitempty"`
+}
+
+func (s *software) flatten() map[string]interface{} {
+	var r = make(map[string]interface{})
+	if s.Value != "" {
+		r[s.XMLName.Local] = s.Value
+	}
+	if s.Version != "" {
+		r[s.XMLName.Local+"_version"] = s.Version
+	}
+	return r
+}
+
+// ProbeDetails describes a probe and the versions of its software,
+// as sent by probes along with their handshake.
+type ProbeDetails struct {
+	XMLName         xml.Name
+	Attrs           rootAttrs      `xml:",any,attr"`
+	RecordIdentity  recordIdentity `xml:",omitempty"`
+	ProbeName       string         `xml:",omitempty"`
+	SiteName        string         `xml:",omitempty"`
+	ReporterLibrary software       `xml:",omitempty"`
+	Reporter        software       `xml:",omitempty"`
+	Service         []software     `xml:",omitempty"`
+	Origin          origin         `xml:",omitempty"`
+	Fields          fieldList      `xml:",any"`
+	RawXML          []byte         `xml:",innerxml"`
+}
+
+// ParseXML attempts to unmarshal the XML in xb into a ProbeDetails.
+func (pd *ProbeDetails) ParseXML(xb []byte) error {
+	if err := xml.Unmarshal(xb, pd); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Id returns an identification string for the record.
+func (pd *ProbeDetails) Id() string {
+	return pd.RecordIdentity.RecordId
+}
+
+// Type returns the type of the record.
+func (pd *ProbeDetails) Type() string {
+	return pd.XMLName.Local
+}
+
+// Raw returns the unaltered source of the record.
+func (pd *ProbeDetails) Raw() []byte {
+	s := "<" + pd.XMLName.Local + ">" + string(pd.RawXML) + "</" + pd.XMLName.Local + ">"
+	return []byte(s)
+}
+
+// ToURJSON returns a JSON encoding of the Record that keeps the
+// structure of the Usage Record XML.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (pd *ProbeDetails) ToURJSON(indent string) ([]byte, error) {
+	return toURJSON(pd.XMLName, pd.Attrs, pd.RawXML, indent)
+}
+
+// ToXML returns the record as canonical XML, with its namespaces
+// and extension elements.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (pd *ProbeDetails) ToXML(indent string) ([]byte, error) {
+	return toURXML(pd.XMLName, pd.Attrs, pd.RawXML, indent)
+}
+
+func (pd *ProbeDetails) durations() []namedDuration {
+	return pd.Fields.durations()
+}
+
+// Versions returns the versions of the probe software, keyed by
+// component name.
+func (pd *ProbeDetails) Versions() map[string]string {
+	var v = make(map[string]string)
+	for _, s := range append([]software{pd.ReporterLibrary, pd.Reporter}, pd.Service...) {
+		if s.Value != "" {
+			v[s.Value] = s.Version
+		}
+	}
+	return v
+}
+
+// ToJSON returns a JSON encoding of the Record, with certain elements
+// transformed to fit the GRACC Raw Record schema.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+//
+// Services are flattened into a `Service_<name>: <version>` map.
+func (pd *ProbeDetails) ToJSON(indent string) ([]byte, error) {
+	var r = make(map[string]interface{})
+
+	r["type"] = "ProbeDetails"
+
+	for k, v := range pd.RecordIdentity.flatten() {
+		r[k] = v
+	}
+	if pd.ProbeName != "" {
+		r["ProbeName"] = pd.ProbeName
+	}
+	if pd.SiteName != "" {
+		r["SiteName"] = pd.SiteName
+	}
+
+	// software versions
+	for k, v := range pd.ReporterLibrary.flatten() {
+		r[k] = v
+	}
+	for k, v := range pd.Reporter.flatten() {
+		r[k] = v
+	}
+	for _, s := range pd.Service {
+		if s.Value != "" {
+			r["Service_"+strings.Map(mapForKey, s.Value)] = s.Version
+		}
+	}
+
+	// flatten other fields
+	for k, v := range pd.Fields.flatten() {
+		r[k] = v
+	}
+
+	// origin
+	for k, v := range pd.Origin.flatten() {
+		r[k] = v
+	}
+
+	// warnings
+	addWarnings(r, pd)
+
+	// add XML
+	r["RawXML"] = string(pd.Raw())
+
+	if indent != "" {
+		return json.MarshalIndent(r, "", indent)
+	}
+	return json.Marshal(r)
+}
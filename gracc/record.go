@@ -3,16 +3,31 @@ package gracc
 import (
 	"encoding/xml"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 	"time"
-
-	duration "github.com/retzkek/iso8601duration"
 )
 
 // XMLRecord is a generic structure for unmarshalling unknown XML data.
 type XMLRecord struct {
 	XMLName  xml.Name
-	InnerXML string `xml:",innerxml"`
+	Attrs    []xml.Attr `xml:",any,attr"`
+	InnerXML string     `xml:",innerxml"`
+}
+
+// Generic converts the XMLRecord into a GenericRecord. The record element is
+// rebuilt with its namespace declarations, so that prefixed child elements
+// and attributes resolve.
+func (x *XMLRecord) Generic() (*GenericRecord, error) {
+	buf, _ := rebuildRecordXML(x.XMLName, x.Attrs, []byte(x.InnerXML))
+	gr, err := ParseGenericRecordXML(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	gr.XMLName = x.XMLName
+	gr.Attrs = x.Attrs
+	return gr, nil
 }
 
 // Record is an interface implemented by all record types.
@@ -20,36 +35,116 @@ type Record interface {
 	Id() string
 	Type() string
 	ToJSON(indent string) ([]byte, error)
+	ToURJSON(indent string) ([]byte, error)
+	ToXML(indent string) ([]byte, error)
 	Raw() []byte
 }
 
+// EMI record namespaces. EMI CAR records have a UsageRecord root element,
+// and EMI StAR records a StorageUsageRecord root element.
+const (
+	EMICARNamespace  = "http://eu-emi.eu/namespaces/2012/11/computerecord"
+	EMIStARNamespace = "http://eu-emi.eu/namespaces/2011/02/storagerecord"
+)
+
+// urRecordType returns the Usage Record type of a record element. EMI
+// records are UR 2.0 records with other element names.
+func urRecordType(name xml.Name) string {
+	switch name.Space {
+	case EMICARNamespace:
+		return "ComputeRecord"
+	case EMIStARNamespace:
+		return "StorageRecord"
+	}
+	return name.Local
+}
+
+// rootAttrs holds the attributes of a record element, including namespace
+// declarations. They are kept for re-encoding the record, but not marshalled
+// along with the record struct.
+type rootAttrs []xml.Attr
+
+func (a *rootAttrs) UnmarshalXMLAttr(attr xml.Attr) error {
+	*a = append(*a, attr)
+	return nil
+}
+
+func (a rootAttrs) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	return xml.Attr{}, nil
+}
+
 // ParseRecordXML will attempt to unmarshall the XML in buf into one of the
-// known record types.
+// known record types. If durations are strict, a record with an invalid
+// duration is not returned, only a *DurationError.
 func ParseRecordXML(buf []byte) (Record, error) {
 	var rec XMLRecord
 	if err := xml.Unmarshal(buf, &rec); err != nil {
 		return nil, fmt.Errorf("unable to parse record XML")
 	}
-	switch rec.XMLName.Local {
+	switch urRecordType(rec.XMLName) {
 	case "UsageRecord", "JobUsageRecord":
 		var jur JobUsageRecord
 		if err := jur.ParseXML(buf); err == nil {
-			return &jur, nil
+			return checked(&jur)
 		}
 	case "StorageElement":
 		var se StorageElement
 		if err := se.ParseXML(buf); err == nil {
-			return &se, nil
+			return checked(&se)
 		}
 	case "StorageElementRecord":
 		var ser StorageElementRecord
 		if err := ser.ParseXML(buf); err == nil {
-			return &ser, nil
+			return checked(&ser)
+		}
+	case "ComputeElement":
+		var ce ComputeElement
+		if err := ce.ParseXML(buf); err == nil {
+			return checked(&ce)
+		}
+	case "ComputeElementRecord":
+		var cer ComputeElementRecord
+		if err := cer.ParseXML(buf); err == nil {
+			return checked(&cer)
+		}
+	case "Subcluster":
+		var sc Subcluster
+		if err := sc.ParseXML(buf); err == nil {
+			return checked(&sc)
+		}
+	case "MetricRecord":
+		var mr MetricRecord
+		if err := mr.ParseXML(buf); err == nil {
+			return checked(&mr)
+		}
+	case "ComputeRecord":
+		var cr ComputeRecord
+		if err := cr.ParseXML(buf); err == nil {
+			return checked(&cr)
+		}
+	case "StorageRecord":
+		var sr StorageRecord
+		if err := sr.ParseXML(buf); err == nil {
+			return checked(&sr)
+		}
+	case "ProbeDetails":
+		var pd ProbeDetails
+		if err := pd.ParseXML(buf); err == nil {
+			return checked(&pd)
 		}
 	}
 	return nil, fmt.Errorf("unable to unmarshall XML into record")
 }
 
+// checked returns rec, or if durations are strict and rec has an invalid
+// duration, nil and the first duration error.
+func checked(rec Record) (Record, error) {
+	if err := checkDurations(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
 type origin struct {
 	Hop        int       `xml:"hop,attr"`
 	ServerDate time.Time `xml:",omitempty"`
@@ -113,9 +208,200 @@ func (f *field) flatten() map[string]interface{} {
 	if f.Metric != "" {
 		r[f.XMLName.Local+"_metric"] = f.Metric
 	}
+	if numericFields[strings.SplitN(f.XMLName.Local, "_", 2)[0]] {
+		typeValue(r, f.XMLName.Local, f.StorageUnit)
+	}
+	return r
+}
+
+// numericFields are the elements whose values are converted to numbers
+// if options.NumericValues is set.
+var numericFields = map[string]bool{
+	"Processors": true,
+	"NodeCount":  true,
+	"Njobs":      true,
+	"Memory":     true,
+	"Swap":       true,
+	"Disk":       true,
+	"Network":    true,
+	"Status":     true,
+}
+
+// storageUnits are the size in bytes of each storage unit. As in the Usage
+// Record specification, multiples are powers of 1024 whether or not the
+// unit is written as such, and a lowercase "b" is bits.
+var storageUnits = map[string]float64{
+	"B":   1,
+	"KB":  1 << 10,
+	"MB":  1 << 20,
+	"GB":  1 << 30,
+	"TB":  1 << 40,
+	"PB":  1 << 50,
+	"EB":  1 << 60,
+	"kB":  1 << 10,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+	"PiB": 1 << 50,
+	"EiB": 1 << 60,
+	"b":   1.0 / 8,
+	"Kb":  (1 << 10) / 8,
+	"Mb":  (1 << 20) / 8,
+	"Gb":  (1 << 30) / 8,
+	"Tb":  (1 << 40) / 8,
+	"Pb":  (1 << 50) / 8,
+	"Eb":  (1 << 60) / 8,
+}
+
+// typeValue converts the string value r[k] to a number if options.NumericValues
+// is set, and if options.NormalizeUnits is set and unit is a storage unit, to
+// bytes, keeping the original value and unit in <k>_originalValue and
+// <k>_originalStorageUnit. Values that are not numbers are left as is.
+func typeValue(r map[string]interface{}, k, unit string) {
+	v, ok := r[k].(string)
+	if !ok {
+		return
+	}
+	size, isStorage := storageUnits[unit]
+	normalize := options.NormalizeUnits && isStorage
+	if !options.NumericValues && !normalize {
+		return
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return
+	}
+	r[k] = n
+	if normalize {
+		r[k] = n * size
+		r[k+"_storageUnit"] = "B"
+		r[k+"_originalValue"] = n
+		r[k+"_originalStorageUnit"] = unit
+	}
+}
+
+// fieldList is a list of other elements in a record, which are flattened
+// into the top level of the JSON record.
+type fieldList []field
+
+func (fl fieldList) flatten() map[string]interface{} {
+	ff := make([]flatField, len(fl))
+	for i := range fl {
+		ff[i] = &fl[i]
+	}
+	return flattenFields(ff)
+}
+
+func (fl fieldList) durations() []namedDuration {
+	var d []namedDuration
+	for _, f := range fl {
+		if f.PhaseUnit != "" {
+			d = append(d, namedDuration{fieldKey(f.XMLName) + "_phaseUnit", f.PhaseUnit})
+		}
+	}
+	return d
+}
+
+func (f *field) name() xml.Name {
+	return f.XMLName
+}
+
+func (f *field) label() string {
+	if f.Metric != "" {
+		return f.Metric
+	}
+	return f.Description
+}
+
+func (f *field) flattenAs(key string) map[string]interface{} {
+	ff := *f
+	ff.XMLName.Local = key
+	return ff.flatten()
+}
+
+// flatField is an element that can be flattened under a given key.
+type flatField interface {
+	// name returns the element name.
+	name() xml.Name
+	// label returns a string that distinguishes the element from others
+	// of the same name (e.g. its metric).
+	label() string
+	// flattenAs flattens the element as if its name were key.
+	flattenAs(key string) map[string]interface{}
+}
+
+// flattenFields flattens a list of elements, handling elements that appear
+// more than once according to options.Repeated.
+func flattenFields(fields []flatField) map[string]interface{} {
+	var r = make(map[string]interface{})
+	count := make(map[string]int)
+	for _, f := range fields {
+		count[fieldKey(f.name())]++
+	}
+	seen := make(map[string]int)
+	for _, f := range fields {
+		k := fieldKey(f.name())
+		n := seen[k]
+		seen[k]++
+		if count[k] > 1 {
+			switch options.Repeated {
+			case RepeatedArray:
+				for kk, v := range f.flattenAs(k) {
+					a, ok := r[kk].([]interface{})
+					if !ok {
+						a = make([]interface{}, count[k])
+						r[kk] = a
+					}
+					a[n] = v
+				}
+				continue
+			case RepeatedSuffix:
+				if n > 0 {
+					sk := k
+					if l := f.label(); l != "" {
+						sk = k + "_" + strings.Map(mapForKey, l)
+					}
+					if _, ok := r[sk]; ok || sk == k || count[sk] > 0 {
+						sk = fmt.Sprintf("%s_%d", k, n)
+					}
+					k = sk
+				}
+			}
+		}
+		for kk, v := range f.flattenAs(k) {
+			r[kk] = v
+		}
+	}
 	return r
 }
 
+// urNamespaces are the Usage Record namespaces (or their usual prefixes,
+// if undeclared), the elements of which are keyed by local name only.
+var urNamespaces = map[string]bool{
+	"":               true,
+	URWGNamespace:    true,
+	URFNamespace:     true,
+	UR2Namespace:     true,
+	EMICARNamespace:  true,
+	EMIStARNamespace: true,
+	"urwg":           true,
+	"urf":            true,
+	"ur":             true,
+}
+
+// fieldKey returns the key for an element, qualified by namespace
+// if it is not in a Usage Record namespace.
+func fieldKey(n xml.Name) string {
+	if urNamespaces[n.Space] {
+		return n.Local
+	}
+	if p, ok := options.Namespaces[n.Space]; ok {
+		return p + ":" + n.Local
+	}
+	return strings.Map(mapForKey, n.Space) + ":" + n.Local
+}
+
 type timeDuration struct {
 	XMLName     xml.Name
 	Value       string `xml:",chardata"`
@@ -164,11 +450,3 @@ func mapForKey(c rune) rune {
 	}
 	return c
 }
-
-func convertDurationToSeconds(dur string) float64 {
-	d, err := duration.ParseString(dur)
-	if err != nil {
-		return 0.0
-	}
-	return d.ToDuration().Seconds()
-}
@@ -3,7 +3,10 @@ package gracc
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -19,11 +22,22 @@ var Tests = []RecordTest{
 	{"test_data/JobUsageRecord04.xml", "test_data/JobUsageRecord04.json"},
 	{"test_data/JobUsageRecord05.xml", "test_data/JobUsageRecord05.json"},
 	{"test_data/JobUsageRecord06.xml", "test_data/JobUsageRecord06.json"},
+	{"test_data/JobUsageRecord07.xml", "test_data/JobUsageRecord07.json"},
+	{"test_data/JobUsageRecord08.xml", "test_data/JobUsageRecord08.json"},
 	{"test_data/StorageElement01.xml", "test_data/StorageElement01.json"},
 	{"test_data/StorageElement02.xml", "test_data/StorageElement02.json"},
 	{"test_data/StorageElementRecord01.xml", "test_data/StorageElementRecord01.json"},
 	{"test_data/StorageElementRecord02.xml", "test_data/StorageElementRecord02.json"},
 	{"test_data/UsageRecord01.xml", "test_data/UsageRecord01.json"},
+	{"test_data/ComputeElement01.xml", "test_data/ComputeElement01.json"},
+	{"test_data/ComputeElementRecord01.xml", "test_data/ComputeElementRecord01.json"},
+	{"test_data/Subcluster01.xml", "test_data/Subcluster01.json"},
+	{"test_data/MetricRecord01.xml", "test_data/MetricRecord01.json"},
+	{"test_data/ProbeDetails01.xml", "test_data/ProbeDetails01.json"},
+	{"test_data/ComputeRecord01.xml", "test_data/ComputeRecord01.json"},
+	{"test_data/StorageRecord01.xml", "test_data/StorageRecord01.json"},
+	{"test_data/ComputeRecord02.xml", "test_data/ComputeRecord02.json"},
+	{"test_data/StorageRecord02.xml", "test_data/StorageRecord02.json"},
 }
 
 func TestUnmarshal(t *testing.T) {
@@ -43,38 +57,295 @@ func TestUnmarshal(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		// read reference JSON
-		g, err := os.Open(jt.RefJSONFile)
+		t.Logf("=== %s ===\n", jt.SourceXMLFile)
+		compareJSON(t, v, jt.RefJSONFile)
+
+		// allow/deny lists and stages match on Type
+		j, err := v.ToJSON("")
 		if err != nil {
-			t.Error(err)
+			t.Fatal(err)
 		}
-		defer g.Close()
-		buf.Reset()
-		if _, err := buf.ReadFrom(g); err != nil {
-			t.Error(err)
+		var r map[string]interface{}
+		if err := json.Unmarshal(j, &r); err != nil {
+			t.Fatal(err)
 		}
-		var rref map[string]interface{}
-		if err := json.Unmarshal(buf.Bytes(), &rref); err != nil {
-			t.Error(err)
+		if r["type"] != v.Type() {
+			t.Errorf("%s: Type() is %s, but JSON type is %v", jt.SourceXMLFile, v.Type(), r["type"])
 		}
+	}
+}
 
-		t.Logf("=== %s ===\n", jt.SourceXMLFile)
-		if j, err := v.ToJSON("    "); err != nil {
+func TestGenericRecord(t *testing.T) {
+	buf, err := ioutil.ReadFile("test_data/GenericRecord01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseRecordXML(buf); err == nil {
+		t.Error("expected error parsing unknown record type")
+	}
+	v, err := ParseGenericRecordXML(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := v.Id(); id != "future.example.edu:1234.0" {
+		t.Errorf("expected Id 'future.example.edu:1234.0', got '%s'", id)
+	}
+	compareJSON(t, v, "test_data/GenericRecord01.json")
+}
+
+func TestPassThrough(t *testing.T) {
+	rec, err := ioutil.ReadFile("test_data/GenericRecord01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bun RecordBundle
+	if err := xml.Unmarshal([]byte("<RecordEnvelope>"+string(rec)+"</RecordEnvelope>"), &bun); err != nil {
+		t.Fatal(err)
+	}
+	if len(bun.OtherRecords) != 1 {
+		t.Fatalf("expected 1 OtherRecord, got %d", len(bun.OtherRecords))
+	}
+	bun.PassThrough()
+	if len(bun.OtherRecords) != 0 || len(bun.GenericRecords) != 1 || bun.RecordCount() != 1 {
+		t.Fatalf("expected 1 GenericRecord after PassThrough, got %d (%d other)", len(bun.GenericRecords), len(bun.OtherRecords))
+	}
+	for v := range bun.Records() {
+		compareJSON(t, v, "test_data/GenericRecord01.json")
+	}
+}
+
+func TestPassThroughNamespaces(t *testing.T) {
+	rec, err := ioutil.ReadFile("test_data/GenericRecord02.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bun RecordBundle
+	if err := xml.Unmarshal([]byte("<RecordEnvelope>"+string(rec)+"</RecordEnvelope>"), &bun); err != nil {
+		t.Fatal(err)
+	}
+	bun.PassThrough()
+	if len(bun.GenericRecords) != 1 {
+		t.Fatalf("expected 1 GenericRecord after PassThrough, got %d", len(bun.GenericRecords))
+	}
+	gr := &bun.GenericRecords[0]
+	compareJSON(t, gr, "test_data/GenericRecord02.json")
+	// the raw record must declare the prefixes used by its children
+	var raw GenericRecord
+	if err := xml.Unmarshal(gr.Raw(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range raw.Fields {
+		if f.XMLName.Space == "urwg" || f.XMLName.Space == "ext" {
+			t.Errorf("%s has undeclared prefix %s in raw record", f.XMLName.Local, f.XMLName.Space)
+		}
+	}
+}
+
+func TestEMIBundle(t *testing.T) {
+	env := `<RecordEnvelope><urf:UsageRecord xmlns:urf="http://schema.ogf.org/urf/2003/09/urf"><urf:RecordIdentity urf:recordId="r1"/></urf:UsageRecord>`
+	for _, f := range []string{"test_data/ComputeRecord02.xml", "test_data/StorageRecord02.xml"} {
+		rec, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env += string(rec)
+	}
+	env += `</RecordEnvelope>`
+	var bun RecordBundle
+	if err := xml.Unmarshal([]byte(env), &bun); err != nil {
+		t.Fatal(err)
+	}
+	if len(bun.UsageRecords) != 1 || len(bun.EMIComputeRecords) != 1 || len(bun.EMIStorageRecords) != 1 || bun.RecordCount() != 3 {
+		t.Fatalf("expected 1 UsageRecord, EMI compute record and EMI storage record, got %d, %d and %d of %d",
+			len(bun.UsageRecords), len(bun.EMIComputeRecords), len(bun.EMIStorageRecords), bun.RecordCount())
+	}
+	var types []string
+	for rec := range bun.Records() {
+		types = append(types, rec.Type())
+	}
+	expect := []string{"JobUsageRecord", "JobUsageRecord", "StorageElementRecord"}
+	if !reflect.DeepEqual(types, expect) {
+		t.Errorf("expected record types %v, got %v", expect, types)
+	}
+}
+
+func TestRepeatedFields(t *testing.T) {
+	defer SetOptions(Options{})
+	buf, err := ioutil.ReadFile("test_data/JobUsageRecord07.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := map[string]string{"http://example.org/gratia/ext": "ext"}
+	for _, rt := range []struct {
+		opts Options
+		ref  string
+	}{
+		{Options{}, "test_data/JobUsageRecord07.json"},
+		{Options{Repeated: RepeatedOverwrite}, "test_data/JobUsageRecord07.json"},
+		{Options{Repeated: RepeatedArray, Namespaces: ns}, "test_data/JobUsageRecord07-array.json"},
+		{Options{Repeated: RepeatedSuffix, Namespaces: ns}, "test_data/JobUsageRecord07-suffix.json"},
+	} {
+		if err := SetOptions(rt.opts); err != nil {
+			t.Fatal(err)
+		}
+		v, err := ParseRecordXML(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("=== %s ===\n", rt.ref)
+		compareJSON(t, v, rt.ref)
+	}
+	if err := SetOptions(Options{Repeated: "bogus"}); err == nil {
+		t.Error("expected error setting unknown repeated handling")
+	}
+}
+
+func TestNumericValues(t *testing.T) {
+	defer SetOptions(Options{})
+	if err := SetOptions(Options{NumericValues: true, NormalizeUnits: true}); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile("test_data/JobUsageRecord02.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ParseRecordXML(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareJSON(t, v, "test_data/JobUsageRecord02-numeric.json")
+}
+
+func TestTypeValue(t *testing.T) {
+	defer SetOptions(Options{})
+	for _, tt := range []struct {
+		opts  Options
+		value string
+		unit  string
+		want  interface{}
+	}{
+		{Options{}, "4", "", "4"},
+		{Options{NumericValues: true}, "4", "", 4.0},
+		{Options{NumericValues: true}, "4", "KB", 4.0},
+		{Options{NumericValues: true}, "NaN", "", "NaN"},
+		{Options{NumericValues: true}, "completed", "", "completed"},
+		{Options{NormalizeUnits: true}, "4", "", "4"},
+		{Options{NormalizeUnits: true}, "4", "KB", 4096.0},
+		{Options{NormalizeUnits: true}, "2", "GiB", 2147483648.0},
+		{Options{NormalizeUnits: true}, "16", "b", 2.0},
+		{Options{NormalizeUnits: true}, "1.5", "furlongs", "1.5"},
+	} {
+		SetOptions(tt.opts)
+		r := map[string]interface{}{"Memory": tt.value}
+		typeValue(r, "Memory", tt.unit)
+		if r["Memory"] != tt.want {
+			t.Errorf("%+v %s %s: expected %v, got %v", tt.opts, tt.value, tt.unit, tt.want, r["Memory"])
+		}
+	}
+}
+
+func TestStrictDurations(t *testing.T) {
+	defer SetOptions(Options{})
+	if err := SetOptions(Options{Durations: DurationsStrict}); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile("test_data/JobUsageRecord08.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := ParseRecordXML(buf); err == nil {
+		t.Error("expected error parsing record with invalid WallDuration")
+	} else if de, ok := err.(*DurationError); !ok || de.Field != "WallDuration" {
+		t.Errorf("expected WallDuration error, got %v", err)
+	} else if rec != nil {
+		t.Errorf("expected nil record with error, got %v", rec)
+	}
+	buf, err = ioutil.ReadFile("test_data/JobUsageRecord01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseRecordXML(buf); err != nil {
+		t.Error(err)
+	}
+}
+
+// compareJSON checks that all fields in the JSON encoding of v, other than
+// RawXML, match the reference JSON in file ref.
+func compareJSON(t *testing.T, v Record, ref string) {
+	// read reference JSON
+	buf, err := ioutil.ReadFile(ref)
+	if err != nil {
+		t.Error(err)
+	}
+	var rref map[string]interface{}
+	if err := json.Unmarshal(buf, &rref); err != nil {
+		t.Error(err)
+	}
+
+	if j, err := v.ToJSON("    "); err != nil {
+		t.Error(err)
+	} else {
+		//t.Logf("%s", j)
+		// Compare
+		var r map[string]interface{}
+		if err := json.Unmarshal(j, &r); err != nil {
 			t.Error(err)
-		} else {
-			//t.Logf("%s", j)
-			// Compare
-			var r map[string]interface{}
-			if err := json.Unmarshal(j, &r); err != nil {
-				t.Error(err)
+		}
+		delete(r, "RawXML")
+		for k, v := range r {
+			if !reflect.DeepEqual(v, rref[k]) {
+				t.Logf("'%s' Expected: '%v' Got '%v'", k, rref[k], v)
+				t.Fail()
 			}
-			delete(r, "RawXML")
-			for k, v := range r {
-				if v != rref[k] {
-					t.Logf("'%s' Expected: '%v' Got '%v'", k, rref[k], v)
-					t.Fail()
-				}
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	defer SetOptions(Options{})
+	if err := SetOptions(Options{Classify: []ClassifyRule{
+		{Match: map[string]string{"ResourceType": ""}, Set: map[string]string{"ResourceType": "Batch"}},
+		{Match: map[string]string{"ProbeName": "slurm:*"}, Set: map[string]string{"ResourceType": "HPC", "Facility": "Brazos"}},
+		{Match: map[string]string{"ResourceType": "HPC", "SiteName": "*"}, Set: map[string]string{"Allocated": "true"}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		file   string
+		expect map[string]interface{} // expected values; nil means absent
+	}{
+		{"test_data/JobUsageRecord01.xml", map[string]interface{}{"ResourceType": "AWSVM", "Facility": nil}},
+		{"test_data/JobUsageRecord02.xml", map[string]interface{}{"ResourceType": "Batch", "Facility": nil}},
+		{"test_data/JobUsageRecord04.xml", map[string]interface{}{"ResourceType": "HPC", "Facility": "Brazos", "Allocated": "true"}},
+		{"test_data/JobUsageRecord05.xml", map[string]interface{}{"ResourceType": "BatchPilot"}},
+		{"test_data/ComputeRecord01.xml", map[string]interface{}{"ResourceType": "Batch"}},
+	} {
+		buf, err := ioutil.ReadFile(tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := ParseRecordXML(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j, err := v.ToJSON("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r map[string]interface{}
+		if err := json.Unmarshal(j, &r); err != nil {
+			t.Fatal(err)
+		}
+		for k, want := range tt.expect {
+			if got, ok := r[k]; want == nil && ok {
+				t.Errorf("%s: unexpected %s %v", tt.file, k, got)
+			} else if want != nil && got != want {
+				t.Errorf("%s: expected %s %v, got %v", tt.file, k, want, got)
 			}
 		}
 	}
+
+	if err := SetOptions(Options{Classify: []ClassifyRule{{Match: map[string]string{"ProbeName": "["}}}}); err == nil {
+		t.Error("expected error for bad classify pattern")
+	}
 }
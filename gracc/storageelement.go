@@ -9,10 +9,11 @@ import (
 // StorageElement is a flexible container for distributed storage element information.
 type StorageElement struct {
 	XMLName   xml.Name
+	Attrs     rootAttrs `xml:",any,attr"`
 	UniqueID  string    `xml:",omitempty"`
 	Timestamp time.Time `xml:",omitempty"`
-	Origin    origin    `xml:,omitempty"`
-	Fields    []field   `xml:",any"`
+	Origin    origin    `xml:",omitempty"`
+	Fields    fieldList `xml:",any"`
 	RawXML    []byte    `xml:",innerxml"`
 }
 
@@ -40,6 +41,26 @@ func (se *StorageElement) Raw() []byte {
 	return []byte(s)
 }
 
+// ToURJSON returns a JSON encoding of the Record that keeps the
+// structure of the Usage Record XML.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (se *StorageElement) ToURJSON(indent string) ([]byte, error) {
+	return toURJSON(se.XMLName, se.Attrs, se.RawXML, indent)
+}
+
+// ToXML returns the record as canonical XML, with its namespaces
+// and extension elements.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (se *StorageElement) ToXML(indent string) ([]byte, error) {
+	return toURXML(se.XMLName, se.Attrs, se.RawXML, indent)
+}
+
+func (se *StorageElement) durations() []namedDuration {
+	return se.Fields.durations()
+}
+
 // ToJSON returns a JSON encoding of the Record, with certain elements
 // transformed to fit the GRACC Raw Record schema.
 // Indent specifies the string to use for each indentation level,
@@ -57,10 +78,8 @@ func (se *StorageElement) ToJSON(indent string) ([]byte, error) {
 	}
 
 	// flatten other fields
-	for _, f := range se.Fields {
-		for k, v := range f.flatten() {
-			r[k] = v
-		}
+	for k, v := range se.Fields.flatten() {
+		r[k] = v
 	}
 
 	// origin
@@ -68,6 +87,9 @@ func (se *StorageElement) ToJSON(indent string) ([]byte, error) {
 		r[k] = v
 	}
 
+	// warnings
+	addWarnings(r, se)
+
 	// add XML
 	r["RawXML"] = string(se.Raw())
 
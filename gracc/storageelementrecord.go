@@ -9,6 +9,7 @@ import (
 // StorageElementRecord is a flexible container for storage element usage information.
 type StorageElementRecord struct {
 	XMLName        xml.Name
+	Attrs          rootAttrs `xml:",any,attr"`
 	UniqueID       string    `xml:",omitempty"`
 	Timestamp      time.Time `xml:",omitempty"`
 	TotalSpace     uint64    `xml:",omitempty"`
@@ -16,8 +17,8 @@ type StorageElementRecord struct {
 	UsedSpace      uint64    `xml:",omitempty"`
 	FileCount      uint64    `xml:",omitempty"`
 	FileCountLimit uint64    `xml:",omitempty"`
-	Origin         origin    `xml:,omitempty"`
-	Fields         []field   `xml:",any"`
+	Origin         origin    `xml:",omitempty"`
+	Fields         fieldList `xml:",any"`
 	RawXML         []byte    `xml:",innerxml"`
 }
 
@@ -44,6 +45,26 @@ func (ser *StorageElementRecord) Raw() []byte {
 	return []byte(s)
 }
 
+// ToURJSON returns a JSON encoding of the Record that keeps the
+// structure of the Usage Record XML.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (ser *StorageElementRecord) ToURJSON(indent string) ([]byte, error) {
+	return toURJSON(ser.XMLName, ser.Attrs, ser.RawXML, indent)
+}
+
+// ToXML returns the record as canonical XML, with its namespaces
+// and extension elements.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (ser *StorageElementRecord) ToXML(indent string) ([]byte, error) {
+	return toURXML(ser.XMLName, ser.Attrs, ser.RawXML, indent)
+}
+
+func (ser *StorageElementRecord) durations() []namedDuration {
+	return ser.Fields.durations()
+}
+
 // ToJSON returns a JSON encoding of the Record, with certain elements
 // transformed to fit the GRACC Raw Record schema.
 // Indent specifies the string to use for each indentation level,
@@ -78,10 +99,8 @@ func (ser *StorageElementRecord) ToJSON(indent string) ([]byte, error) {
 	}
 
 	// flatten other fields
-	for _, f := range ser.Fields {
-		for k, v := range f.flatten() {
-			r[k] = v
-		}
+	for k, v := range ser.Fields.flatten() {
+		r[k] = v
 	}
 
 	// origin
@@ -89,6 +108,9 @@ func (ser *StorageElementRecord) ToJSON(indent string) ([]byte, error) {
 		r[k] = v
 	}
 
+	// warnings
+	addWarnings(r, ser)
+
 	// add XML
 	r["RawXML"] = string(ser.Raw())
 
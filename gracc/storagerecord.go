@@ -0,0 +1,155 @@
+package gracc
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+)
+
+// StorageRecord is an OGF Usage Record 2.0 storage record, also known as
+// the EMI Storage Accounting Record. It is converted to the same raw record
+// schema as a StorageElementRecord.
+type StorageRecord struct {
+	XMLName                   xml.Name
+	Attrs                     rootAttrs        `xml:",any,attr"`
+	RecordIdentity            recordIdentity   `xml:",omitempty"`
+	StorageSystem             string           `xml:",omitempty"`
+	StorageShare              string           `xml:",omitempty"`
+	StorageMedia              string           `xml:",omitempty"`
+	Site                      string           `xml:",omitempty"`
+	SubjectIdentity           ur2UserIdentity  `xml:",omitempty"`
+	StartTime                 time.Time        `xml:",omitempty"`
+	EndTime                   time.Time        `xml:",omitempty"`
+	FileCount                 uint64           `xml:",omitempty"`
+	ResourceCapacityUsed      uint64           `xml:",omitempty"`
+	LogicalCapacityUsed       uint64           `xml:",omitempty"`
+	ResourceCapacityAllocated uint64           `xml:",omitempty"`
+	Origin                    origin           `xml:",omitempty"`
+	Fields                    genericFieldList `xml:",any"`
+	RawXML                    []byte           `xml:",innerxml"`
+}
+
+// ParseXML attempts to unmarshal the XML in xb into a StorageRecord.
+func (sr *StorageRecord) ParseXML(xb []byte) error {
+	if err := xml.Unmarshal(xb, sr); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Id returns an identification string for the record.
+func (sr *StorageRecord) Id() string {
+	return sr.RecordIdentity.RecordId
+}
+
+// Type returns the type of the record, which is that of the schema it is
+// mapped to.
+func (sr *StorageRecord) Type() string {
+	return "StorageElementRecord"
+}
+
+// Raw returns the unaltered source of the record.
+func (sr *StorageRecord) Raw() []byte {
+	s := "<" + sr.XMLName.Local + ">" + string(sr.RawXML) + "</" + sr.XMLName.Local + ">"
+	return []byte(s)
+}
+
+// ToURJSON returns a JSON encoding of the Record that keeps the
+// structure of the Usage Record XML.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (sr *StorageRecord) ToURJSON(indent string) ([]byte, error) {
+	return toURJSON(sr.XMLName, sr.Attrs, sr.RawXML, indent)
+}
+
+// ToXML returns the record as canonical Usage Record XML, with its
+// namespaces and extension elements, or a *StructureError if it is not a
+// valid Usage Record.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (sr *StorageRecord) ToXML(indent string) ([]byte, error) {
+	return toURXML(sr.XMLName, sr.Attrs, sr.RawXML, indent)
+}
+
+func (sr *StorageRecord) durations() []namedDuration {
+	return sr.Fields.durations()
+}
+
+// ToJSON returns a JSON encoding of the Record, with elements mapped
+// to the GRACC Raw Record schema for a StorageElementRecord.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (sr *StorageRecord) ToJSON(indent string) ([]byte, error) {
+	var r = make(map[string]interface{})
+
+	// flatten other fields first, so that they don't clobber mapped fields
+	for k, v := range sr.Fields.flatten() {
+		r[k] = v
+	}
+
+	r["type"] = "StorageElementRecord"
+	r["ur_version"] = UR2Version
+
+	for k, v := range sr.RecordIdentity.flatten() {
+		r[k] = v
+	}
+	for k, v := range sr.SubjectIdentity.flatten() {
+		r[k] = v
+	}
+
+	// the storage element is identified by system and share
+	r["UniqueID"] = sr.StorageSystem
+	if sr.StorageShare != "" {
+		r["UniqueID"] = sr.StorageSystem + ":" + sr.StorageShare
+		r["StorageShare"] = sr.StorageShare
+	}
+	r["StorageSystem"] = sr.StorageSystem
+	if sr.StorageMedia != "" {
+		r["StorageType"] = sr.StorageMedia
+	}
+	if sr.Site != "" {
+		r["SiteName"] = sr.Site
+	}
+
+	// the measurement covers StartTime to EndTime; Timestamp is the end.
+	if !sr.StartTime.IsZero() {
+		r["StartTime"] = sr.StartTime.Format(time.RFC3339)
+	}
+	if !sr.EndTime.IsZero() {
+		r["EndTime"] = sr.EndTime.Format(time.RFC3339)
+		r["Timestamp"] = sr.EndTime.Format(time.RFC3339)
+	}
+
+	// data fields, in bytes
+	if sr.ResourceCapacityUsed > 0 {
+		r["UsedSpace"] = sr.ResourceCapacityUsed
+	}
+	if sr.LogicalCapacityUsed > 0 {
+		r["LogicalUsedSpace"] = sr.LogicalCapacityUsed
+	}
+	if sr.ResourceCapacityAllocated > 0 {
+		r["TotalSpace"] = sr.ResourceCapacityAllocated
+		if sr.ResourceCapacityAllocated >= sr.ResourceCapacityUsed {
+			r["FreeSpace"] = sr.ResourceCapacityAllocated - sr.ResourceCapacityUsed
+		}
+	}
+	if sr.FileCount > 0 {
+		r["FileCount"] = sr.FileCount
+	}
+
+	// origin
+	for k, v := range sr.Origin.flatten() {
+		r[k] = v
+	}
+
+	// warnings
+	addWarnings(r, sr)
+
+	// add XML
+	r["RawXML"] = string(sr.Raw())
+
+	if indent != "" {
+		return json.MarshalIndent(r, "", indent)
+	}
+	return json.Marshal(r)
+}
@@ -0,0 +1,123 @@
+package gracc
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+)
+
+// Subcluster is a flexible container for information about a homogeneous
+// set of worker nodes attached to a compute element.
+type Subcluster struct {
+	XMLName        xml.Name
+	Attrs          rootAttrs `xml:",any,attr"`
+	UniqueID       string    `xml:",omitempty"`
+	Timestamp      time.Time `xml:",omitempty"`
+	Cores          uint64    `xml:",omitempty"`
+	Hosts          uint64    `xml:",omitempty"`
+	Cpus           uint64    `xml:",omitempty"`
+	RAM            uint64    `xml:",omitempty"`
+	BenchmarkValue float64   `xml:",omitempty"`
+	Origin         origin    `xml:",omitempty"`
+	Fields         fieldList `xml:",any"`
+	RawXML         []byte    `xml:",innerxml"`
+}
+
+// ParseXML attempts to unmarshal the XML in xb into a Subcluster.
+func (sc *Subcluster) ParseXML(xb []byte) error {
+	if err := xml.Unmarshal(xb, sc); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Id returns an identification string for the record.
+func (sc *Subcluster) Id() string {
+	return sc.UniqueID
+}
+
+// Type returns the type of the record.
+func (sc *Subcluster) Type() string {
+	return sc.XMLName.Local
+}
+
+// Raw returns the unaltered source of the record.
+func (sc *Subcluster) Raw() []byte {
+	s := "<" + sc.XMLName.Local + ">" + string(sc.RawXML) + "</" + sc.XMLName.Local + ">"
+	return []byte(s)
+}
+
+// ToURJSON returns a JSON encoding of the Record that keeps the
+// structure of the Usage Record XML.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (sc *Subcluster) ToURJSON(indent string) ([]byte, error) {
+	return toURJSON(sc.XMLName, sc.Attrs, sc.RawXML, indent)
+}
+
+// ToXML returns the record as canonical XML, with its namespaces
+// and extension elements.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (sc *Subcluster) ToXML(indent string) ([]byte, error) {
+	return toURXML(sc.XMLName, sc.Attrs, sc.RawXML, indent)
+}
+
+func (sc *Subcluster) durations() []namedDuration {
+	return sc.Fields.durations()
+}
+
+// ToJSON returns a JSON encoding of the Record, with certain elements
+// transformed to fit the GRACC Raw Record schema.
+// Indent specifies the string to use for each indentation level,
+// if empty no indentation or pretty-printing is performed.
+func (sc *Subcluster) ToJSON(indent string) ([]byte, error) {
+	var r = make(map[string]interface{})
+
+	r["type"] = "Subcluster"
+
+	r["UniqueID"] = sc.UniqueID
+
+	// Standard time instants
+	if !sc.Timestamp.IsZero() {
+		r["Timestamp"] = sc.Timestamp.Format(time.RFC3339)
+	}
+
+	// data fields
+	if sc.Cores > 0 {
+		r["Cores"] = sc.Cores
+	}
+	if sc.Hosts > 0 {
+		r["Hosts"] = sc.Hosts
+	}
+	if sc.Cpus > 0 {
+		r["Cpus"] = sc.Cpus
+	}
+	if sc.RAM > 0 {
+		r["RAM"] = sc.RAM
+	}
+	if sc.BenchmarkValue > 0 {
+		r["BenchmarkValue"] = sc.BenchmarkValue
+	}
+
+	// flatten other fields
+	for k, v := range sc.Fields.flatten() {
+		r[k] = v
+	}
+
+	// origin
+	for k, v := range sc.Origin.flatten() {
+		r[k] = v
+	}
+
+	// warnings
+	addWarnings(r, sc)
+
+	// add XML
+	r["RawXML"] = string(sc.Raw())
+
+	if indent != "" {
+		return json.MarshalIndent(r, "", indent)
+	}
+	return json.Marshal(r)
+}
@@ -0,0 +1,278 @@
+package gracc
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The UR JSON encoding keeps the structure of the record XML, rather than
+// flattening it as ToJSON does:
+//
+//   - "type" is the name of the record element. The names of EMI records are
+//     qualified with their usual prefix (e.g. "urf:UsageRecord"), since
+//     the local name alone does not identify them.
+//   - "namespaces" maps prefixes to URIs for any namespaces used other than the
+//     Usage Record ones, and the EMI namespace of an EMI record. It is omitted
+//     if there are none.
+//   - Each attribute is a key "@<name>" in the object for its element. Elements
+//     and attributes in a Usage Record namespace have their local name, others
+//     are qualified with their prefix (e.g. "@xsi:schemaLocation").
+//   - An element with no attributes or children is a string. Otherwise it is an
+//     object, with its attributes and children, and its text, if any, in "#text".
+//     Text is kept as is, unless it is only whitespace.
+//   - Elements that may be repeated in a Usage Record (see urArrays) are always
+//     arrays. Other elements are arrays only if they are repeated.
+//
+// For example:
+//
+//	{
+//	    "type": "JobUsageRecord",
+//	    "RecordIdentity": {"@recordId": "...", "@createTime": "..."},
+//	    "JobIdentity": {"GlobalJobId": "...", "LocalJobId": "..."},
+//	    "CpuDuration": [{"@usageType": "user", "#text": "PT1M"}],
+//	    "Resource": [{"@description": "ResourceType", "#text": "Batch"}],
+//	    "ProbeName": "condor:ce.example.edu"
+//	}
+//
+// ParseURJSON converts the encoding back into a Record.
+
+// urArrays are the elements that are always encoded as arrays.
+var urArrays = map[string]bool{
+	"ProcessId":          true,
+	"CpuDuration":        true,
+	"Network":            true,
+	"Disk":               true,
+	"Memory":             true,
+	"Swap":               true,
+	"NodeCount":          true,
+	"Processors":         true,
+	"TimeDuration":       true,
+	"TimeInstant":        true,
+	"ServiceLevel":       true,
+	"Host":               true,
+	"Resource":           true,
+	"ConsumableResource": true,
+	"PhaseResource":      true,
+	"VolumeResource":     true,
+	"GroupAttribute":     true,
+	"Service":            true,
+}
+
+// urEncoder builds the UR JSON encoding of a record, keeping track
+// of the namespaces used.
+type urEncoder struct {
+	// prefixes maps namespace URIs to prefixes
+	prefixes map[string]string
+	// namespaces maps the prefixes used to namespace URIs
+	namespaces map[string]string
+}
+
+// key returns the key for an element or attribute name.
+func (e *urEncoder) key(n xml.Name) string {
+	if urNamespaces[n.Space] {
+		return n.Local
+	}
+	if !strings.ContainsAny(n.Space, ":/") {
+		// undeclared prefix
+		return n.Space + ":" + n.Local
+	}
+	p, ok := e.prefixes[n.Space]
+	if !ok {
+		p = fmt.Sprintf("ns%d", len(e.prefixes)+1)
+		e.prefixes[n.Space] = p
+	}
+	e.namespaces[p] = n.Space
+	return p + ":" + n.Local
+}
+
+// element decodes the element started by start.
+func (e *urEncoder) element(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	var obj = make(map[string]interface{})
+	for _, a := range start.Attr {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		obj["@"+e.key(a.Name)] = a.Value
+	}
+	var text bytes.Buffer
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			v, err := e.element(d, t)
+			if err != nil {
+				return nil, err
+			}
+			k := e.key(t.Name)
+			if cur, ok := obj[k]; ok {
+				if a, ok := cur.([]interface{}); ok {
+					obj[k] = append(a, v)
+				} else {
+					obj[k] = []interface{}{cur, v}
+				}
+			} else if urArrays[k] {
+				obj[k] = []interface{}{v}
+			} else {
+				obj[k] = v
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			// whitespace-only text is just formatting
+			s := text.String()
+			if strings.TrimSpace(s) == "" {
+				s = ""
+			}
+			if len(obj) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				obj["#text"] = s
+			}
+			return obj, nil
+		}
+	}
+}
+
+// toURJSON returns the UR JSON encoding of the record with the
+// given name, attributes, and inner XML.
+func toURJSON(name xml.Name, attrs []xml.Attr, inner []byte, indent string) ([]byte, error) {
+	buf, prefixes := rebuildRecordXML(name, attrs, inner)
+	var e = urEncoder{
+		prefixes:   prefixes,
+		namespaces: make(map[string]string),
+	}
+
+	d := xml.NewDecoder(buf)
+	var r map[string]interface{}
+	for r == nil {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			v, err := e.element(d, start)
+			if err != nil {
+				return nil, err
+			}
+			if r, ok = v.(map[string]interface{}); !ok {
+				r = make(map[string]interface{})
+			}
+		}
+	}
+	r["type"] = name.Local
+	if name.Space == EMICARNamespace || name.Space == EMIStARNamespace {
+		p := urPrefixes[name.Space]
+		if _, used := e.namespaces[p]; used {
+			p = fmt.Sprintf("ns%d", len(e.prefixes)+1)
+		}
+		e.namespaces[p] = name.Space
+		r["type"] = p + ":" + name.Local
+	}
+	if len(e.namespaces) > 0 {
+		r["namespaces"] = e.namespaces
+	}
+
+	if indent != "" {
+		return json.MarshalIndent(r, "", indent)
+	}
+	return json.Marshal(r)
+}
+
+// ParseURJSON converts the UR JSON encoding of a record, as returned by
+// ToURJSON, back into a Record.
+func ParseURJSON(buf []byte) (Record, error) {
+	var r map[string]interface{}
+	if err := json.Unmarshal(buf, &r); err != nil {
+		return nil, err
+	}
+	t, ok := r["type"].(string)
+	if !ok || t == "" {
+		return nil, fmt.Errorf("record type not found")
+	}
+	delete(r, "type")
+	var x bytes.Buffer
+	x.WriteString("<" + t)
+	if ns, ok := r["namespaces"].(map[string]interface{}); ok {
+		for _, p := range sortedKeys(ns) {
+			if uri, ok := ns[p].(string); ok {
+				fmt.Fprintf(&x, " xmlns:%s=\"", p)
+				xml.EscapeText(&x, []byte(uri))
+				x.WriteString("\"")
+			}
+		}
+	}
+	delete(r, "namespaces")
+	if err := writeURElementContents(&x, t, r); err != nil {
+		return nil, err
+	}
+	return ParseRecordXML(x.Bytes())
+}
+
+// writeURElementContents writes the attributes, text, and children in obj,
+// and the end of element name, the start tag of which is still open.
+func writeURElementContents(x *bytes.Buffer, name string, obj map[string]interface{}) error {
+	keys := sortedKeys(obj)
+	for _, k := range keys {
+		if strings.HasPrefix(k, "@") {
+			v, ok := obj[k].(string)
+			if !ok {
+				return fmt.Errorf("attribute %s of %s is not a string", k, name)
+			}
+			fmt.Fprintf(x, " %s=\"", k[1:])
+			xml.EscapeText(x, []byte(v))
+			x.WriteString("\"")
+		}
+	}
+	x.WriteString(">")
+	if v, ok := obj["#text"].(string); ok {
+		xml.EscapeText(x, []byte(v))
+	}
+	for _, k := range keys {
+		if strings.HasPrefix(k, "@") || k == "#text" {
+			continue
+		}
+		if err := writeURElement(x, k, obj[k]); err != nil {
+			return err
+		}
+	}
+	x.WriteString("</" + name + ">")
+	return nil
+}
+
+func writeURElement(x *bytes.Buffer, name string, v interface{}) error {
+	switch vv := v.(type) {
+	case string:
+		x.WriteString("<" + name + ">")
+		xml.EscapeText(x, []byte(vv))
+		x.WriteString("</" + name + ">")
+	case map[string]interface{}:
+		x.WriteString("<" + name)
+		return writeURElementContents(x, name, vv)
+	case []interface{}:
+		for _, e := range vv {
+			if err := writeURElement(x, name, e); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unexpected value for %s: %v", name, v)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
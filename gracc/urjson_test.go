@@ -0,0 +1,76 @@
+package gracc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestURJSON(t *testing.T) {
+	for _, ref := range []RecordTest{
+		{"test_data/JobUsageRecord01.xml", "test_data/JobUsageRecord01-ur.json"},
+		{"test_data/ComputeRecord01.xml", "test_data/ComputeRecord01-ur.json"},
+	} {
+		buf, err := ioutil.ReadFile(ref.SourceXMLFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := ParseRecordXML(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j, err := v.ToURJSON("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rbuf, err := ioutil.ReadFile(ref.RefJSONFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r, rref map[string]interface{}
+		if err := json.Unmarshal(j, &r); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(rbuf, &rref); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r, rref) {
+			t.Errorf("%s: UR JSON does not match %s:\n%s", ref.SourceXMLFile, ref.RefJSONFile, j)
+		}
+	}
+}
+
+func TestURJSONRoundTrip(t *testing.T) {
+	for _, jt := range Tests {
+		buf, err := ioutil.ReadFile(jt.SourceXMLFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := ParseRecordXML(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j, err := v.ToURJSON("    ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		v2, err := ParseURJSON(j)
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", jt.SourceXMLFile, err, j)
+		}
+		if v2.Type() != v.Type() || v2.Id() != v.Id() {
+			t.Errorf("%s: round trip changed record %s %s to %s %s", jt.SourceXMLFile, v.Type(), v.Id(), v2.Type(), v2.Id())
+		}
+		t.Logf("=== %s ===\n", jt.SourceXMLFile)
+		compareJSON(t, v2, jt.RefJSONFile)
+		j2, err := v2.ToURJSON("    ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(j, j2) {
+			t.Errorf("%s: UR JSON changed in round trip:\n%s\n%s", jt.SourceXMLFile, j, j2)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package gracc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ToXML re-encodes a record as canonical Usage Record XML:
+//
+//   - The record element is in its Usage Record namespace (urwg for UR 1.0 and
+//     Gratia records unless the record used urf, the OGF 2013 namespace for
+//     UR 2.0 records unless the record used an EMI namespace), which is
+//     declared both as the default namespace and with its usual prefix
+//     ("urwg", "urf", "ur" or "sr").
+//   - Elements in a Usage Record namespace, or in no namespace, are unprefixed.
+//   - Usage Record attributes (see urAttrs) are qualified with the record
+//     prefix. Other unqualified attributes (e.g. "hop" on Origin) are kept as is.
+//   - Extension elements and attributes keep their namespace, which is declared
+//     on the record element with the prefix used in the source document, or a
+//     generated "nsN". A prefix that was never declared in the source (as can
+//     happen with records extracted from a bundle) is declared as
+//     "urn:x-gracc:undeclared:<prefix>".
+//   - Durations are rewritten as "PT<seconds>S" if they are not valid
+//     xs:durations but can be parsed (see ParseDuration).
+//   - Whitespace-only text, comments and processing instructions are dropped.
+//
+// UsageRecords and JobUsageRecords are checked against the structural rules of
+// UR 1.0, and ComputeRecords and StorageRecords (including EMI records) must
+// have a RecordIdentity.
+// If a record breaks any rules, a *StructureError is returned.
+
+// Usage Record namespaces
+const (
+	URWGNamespace = "http://www.gridforum.org/2003/ur-wg"
+	URFNamespace  = "http://schema.ogf.org/urf/2003/09/urf"
+	UR2Namespace  = "http://schema.ogf.org/urf/2013/04/urf"
+)
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// urPrefixes are the usual prefixes of the Usage Record namespaces.
+var urPrefixes = map[string]string{
+	URWGNamespace:    "urwg",
+	URFNamespace:     "urf",
+	UR2Namespace:     "ur",
+	EMICARNamespace:  "urf",
+	EMIStARNamespace: "sr",
+}
+
+// urAttrs are the attributes defined by the Usage Record schemas.
+var urAttrs = map[string]bool{
+	"recordId":    true,
+	"createTime":  true,
+	"description": true,
+	"unit":        true,
+	"phaseUnit":   true,
+	"storageUnit": true,
+	"metric":      true,
+	"formula":     true,
+	"usageType":   true,
+	"type":        true,
+	"primary":     true,
+}
+
+// ur1Singletons are the UR 1.0 elements that may appear at most once.
+var ur1Singletons = []string{
+	"RecordIdentity",
+	"JobIdentity",
+	"UserIdentity",
+	"JobName",
+	"Charge",
+	"Status",
+	"WallDuration",
+	"StartTime",
+	"EndTime",
+	"MachineName",
+	"SubmitHost",
+	"Queue",
+	"ProjectName",
+}
+
+// urDurations are the elements whose value is a duration.
+var urDurations = map[string]bool{
+	"WallDuration": true,
+	"CpuDuration":  true,
+	"TimeDuration": true,
+}
+
+// urMetricElements are the UR 1.0 elements with a metric attribute.
+var urMetricElements = map[string]bool{
+	"Network":    true,
+	"Disk":       true,
+	"Memory":     true,
+	"Swap":       true,
+	"NodeCount":  true,
+	"Processors": true,
+}
+
+var urMetrics = map[string]bool{
+	"average": true,
+	"total":   true,
+	"min":     true,
+	"max":     true,
+}
+
+// xsDuration matches a valid (non-negative) xs:duration.
+var xsDuration = regexp.MustCompile(`^P(?:\d+Y)?(?:\d+M)?(?:\d+D)?(?:T(?:\d+H)?(?:\d+M)?(?:\d+(?:\.\d+)?S)?)?$`)
+
+func isXSDuration(s string) bool {
+	return xsDuration.MatchString(s) && s != "P" && !strings.HasSuffix(s, "T")
+}
+
+// canonicalDuration returns d if it is a valid xs:duration, otherwise
+// it is parsed and returned as "PT<seconds>S".
+func canonicalDuration(d string) (string, error) {
+	d = strings.TrimSpace(d)
+	if isXSDuration(d) {
+		return d, nil
+	}
+	secs, err := ParseDuration(d)
+	if err != nil {
+		return d, err
+	}
+	return "PT" + strconv.FormatFloat(secs, 'f', -1, 64) + "S", nil
+}
+
+// StructureError is returned by ToXML for a record that does not
+// follow the structural rules of the Usage Record schema.
+type StructureError struct {
+	Type     string
+	Problems []string
+}
+
+func (e *StructureError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Type, strings.Join(e.Problems, "; "))
+}
+
+// recordNamespace returns the namespace of the canonical record element.
+func recordNamespace(name xml.Name) string {
+	if _, ok := urPrefixes[name.Space]; ok {
+		return name.Space
+	}
+	switch name.Local {
+	case "ComputeRecord", "StorageRecord":
+		return UR2Namespace
+	}
+	switch name.Space {
+	case "urf":
+		return URFNamespace
+	case "ur":
+		return UR2Namespace
+	}
+	return URWGNamespace
+}
+
+// urElement is a child of the record element, as needed for validation.
+type urElement struct {
+	name  string
+	attrs []xml.Attr
+	text  string
+	// indexes of the text tokens of the element
+	textTokens []int
+}
+
+// attr returns the value of a Usage Record attribute of the element.
+func (e *urElement) attr(local string) (string, bool) {
+	for _, a := range e.attrs {
+		if a.Name.Local == local && urNamespaces[a.Name.Space] {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// urXMLEncoder keeps track of namespaces while re-encoding a record.
+type urXMLEncoder struct {
+	prefix string
+	// prefixes declared in the source document, by namespace URI
+	prefixes map[string]string
+	// extension namespaces to declare, by prefix
+	decls map[string]string
+}
+
+// qualify returns the prefixed name of an element or attribute
+// in an extension namespace.
+func (e *urXMLEncoder) qualify(n xml.Name) string {
+	if n.Space == xmlNamespace {
+		return "xml:" + n.Local
+	}
+	uri := n.Space
+	p, ok := e.prefixes[uri]
+	if !strings.ContainsAny(uri, ":/") {
+		// undeclared prefix
+		p, ok = uri, true
+		uri = "urn:x-gracc:undeclared:" + p
+	}
+	if u, used := e.decls[p]; !ok || p == "" || p == e.prefix || (used && u != uri) {
+		for i := len(e.decls) + 1; ; i++ {
+			p = fmt.Sprintf("ns%d", i)
+			if _, used := e.decls[p]; !used {
+				break
+			}
+		}
+	}
+	e.prefixes[uri] = p
+	e.decls[p] = uri
+	return p + ":" + n.Local
+}
+
+func (e *urXMLEncoder) elementName(n xml.Name) string {
+	if urNamespaces[n.Space] {
+		return n.Local
+	}
+	return e.qualify(n)
+}
+
+func (e *urXMLEncoder) attrName(n xml.Name) string {
+	switch {
+	case n.Space == "" && urAttrs[n.Local], n.Space != "" && urNamespaces[n.Space]:
+		return e.prefix + ":" + n.Local
+	case n.Space == "":
+		return n.Local
+	}
+	return e.qualify(n)
+}
+
+func isNamespaceDecl(a xml.Attr) bool {
+	return a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")
+}
+
+// toURXML returns the canonical XML encoding of the record with the given
+// name, root attributes and inner XML.
+func toURXML(name xml.Name, attrs []xml.Attr, inner []byte, indent string) ([]byte, error) {
+	src, prefixes := rebuildRecordXML(name, attrs, inner)
+	ns := recordNamespace(name)
+	e := urXMLEncoder{
+		prefix:   urPrefixes[ns],
+		prefixes: prefixes,
+		decls:    make(map[string]string),
+	}
+	var problems []string
+
+	d := xml.NewDecoder(src)
+	var toks []xml.Token
+	var names []string
+	var children []*urElement
+	var cur *urElement
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := t.Name.Local
+			if len(names) > 0 {
+				n = e.elementName(t.Name)
+			}
+			out := xml.StartElement{Name: xml.Name{Local: n}}
+			for _, a := range t.Attr {
+				if isNamespaceDecl(a) {
+					continue
+				}
+				v := a.Value
+				if a.Name.Local == "phaseUnit" && urNamespaces[a.Name.Space] {
+					if v, err = canonicalDuration(v); err != nil {
+						problems = append(problems, fmt.Sprintf("%s: invalid phaseUnit %q", t.Name.Local, a.Value))
+					}
+				}
+				out.Attr = append(out.Attr, xml.Attr{Name: xml.Name{Local: e.attrName(a.Name)}, Value: v})
+			}
+			if len(names) == 1 && urNamespaces[t.Name.Space] {
+				cur = &urElement{name: t.Name.Local, attrs: t.Attr}
+				children = append(children, cur)
+			}
+			names = append(names, n)
+			toks = append(toks, out)
+		case xml.EndElement:
+			if len(names) == 2 && cur != nil {
+				if urDurations[cur.name] && len(cur.textTokens) > 0 {
+					v, err := canonicalDuration(cur.text)
+					if err != nil {
+						problems = append(problems, fmt.Sprintf("%s: invalid duration %q", cur.name, cur.text))
+					}
+					for _, i := range cur.textTokens {
+						toks[i] = nil
+					}
+					toks[cur.textTokens[0]] = xml.CharData(v)
+				}
+				cur = nil
+			}
+			toks = append(toks, xml.EndElement{Name: xml.Name{Local: names[len(names)-1]}})
+			names = names[:len(names)-1]
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+			if len(names) == 2 && cur != nil {
+				cur.text += string(t)
+				cur.textTokens = append(cur.textTokens, len(toks))
+			}
+			toks = append(toks, t.Copy())
+		}
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("empty record")
+	}
+
+	problems = append(problems, validateUR(urRecordType(name), children)...)
+	if len(problems) > 0 {
+		return nil, &StructureError{Type: name.Local, Problems: problems}
+	}
+
+	// declare namespaces on the record element
+	root := toks[0].(xml.StartElement)
+	rattrs := []xml.Attr{
+		{Name: xml.Name{Local: "xmlns"}, Value: ns},
+		{Name: xml.Name{Local: "xmlns:" + e.prefix}, Value: ns},
+	}
+	var ps []string
+	for p := range e.decls {
+		ps = append(ps, p)
+	}
+	sort.Strings(ps)
+	for _, p := range ps {
+		rattrs = append(rattrs, xml.Attr{Name: xml.Name{Local: "xmlns:" + p}, Value: e.decls[p]})
+	}
+	root.Attr = append(rattrs, root.Attr...)
+	toks[0] = root
+
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	if indent != "" {
+		enc.Indent("", indent)
+	}
+	for _, tok := range toks {
+		if tok == nil {
+			continue
+		}
+		if err := enc.EncodeToken(tok); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// validateUR checks the children of a record element against the structural
+// rules of the Usage Record schema, returning a list of problems.
+func validateUR(recordType string, children []*urElement) []string {
+	var problems []string
+	count := make(map[string]int)
+	for _, c := range children {
+		count[c.name]++
+	}
+	switch recordType {
+	case "UsageRecord", "JobUsageRecord":
+		if count["RecordIdentity"] == 0 {
+			problems = append(problems, "missing RecordIdentity")
+		}
+		for _, s := range ur1Singletons {
+			if count[s] > 1 {
+				problems = append(problems, fmt.Sprintf("%s appears %d times", s, count[s]))
+			}
+		}
+		for _, c := range children {
+			switch {
+			case c.name == "RecordIdentity":
+				problems = append(problems, checkRecordIdentity(c)...)
+			case c.name == "StartTime" || c.name == "EndTime":
+				if _, err := time.Parse(time.RFC3339, strings.TrimSpace(c.text)); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: invalid dateTime %q", c.name, c.text))
+				}
+			case c.name == "CpuDuration":
+				if u, ok := c.attr("usageType"); ok && u != "user" && u != "system" {
+					problems = append(problems, fmt.Sprintf("CpuDuration: invalid usageType %q", u))
+				}
+			case urMetricElements[c.name]:
+				if m, ok := c.attr("metric"); ok && !urMetrics[m] {
+					problems = append(problems, fmt.Sprintf("%s: invalid metric %q", c.name, m))
+				}
+			}
+		}
+	case "ComputeRecord", "StorageRecord":
+		if count["RecordIdentity"] != 1 {
+			problems = append(problems, fmt.Sprintf("RecordIdentity appears %d times", count["RecordIdentity"]))
+		}
+		for _, c := range children {
+			if c.name == "RecordIdentity" {
+				problems = append(problems, checkRecordIdentity(c)...)
+			}
+		}
+	}
+	return problems
+}
+
+func checkRecordIdentity(c *urElement) []string {
+	var problems []string
+	if id, _ := c.attr("recordId"); strings.TrimSpace(id) == "" {
+		problems = append(problems, "RecordIdentity: missing recordId")
+	}
+	if t, ok := c.attr("createTime"); ok {
+		if _, err := time.Parse(time.RFC3339, strings.TrimSpace(t)); err != nil {
+			problems = append(problems, fmt.Sprintf("RecordIdentity: invalid createTime %q", t))
+		}
+	}
+	return problems
+}
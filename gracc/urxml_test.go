@@ -0,0 +1,108 @@
+package gracc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestXML(t *testing.T) {
+	buf, err := ioutil.ReadFile("test_data/JobUsageRecord09.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ParseRecordXML(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := v.ToXML("    ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := ioutil.ReadFile("test_data/JobUsageRecord09-canonical.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(x, bytes.TrimSpace(ref)) {
+		t.Errorf("canonical XML does not match:\n%s", x)
+	}
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	// records that are not valid Usage Records
+	invalid := map[string]bool{
+		"test_data/JobUsageRecord07.xml": true, // repeated Queue
+		"test_data/JobUsageRecord08.xml": true, // invalid WallDuration
+	}
+	for _, jt := range Tests {
+		buf, err := ioutil.ReadFile(jt.SourceXMLFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := ParseRecordXML(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, err := v.ToXML("    ")
+		if invalid[jt.SourceXMLFile] {
+			if _, ok := err.(*StructureError); !ok {
+				t.Errorf("%s: expected StructureError, got %v", jt.SourceXMLFile, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", jt.SourceXMLFile, err)
+		}
+		v2, err := ParseRecordXML(x)
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", jt.SourceXMLFile, err, x)
+		}
+		if v2.Type() != v.Type() || v2.Id() != v.Id() {
+			t.Errorf("%s: round trip changed record %s %s to %s %s", jt.SourceXMLFile, v.Type(), v.Id(), v2.Type(), v2.Id())
+		}
+		t.Logf("=== %s ===\n", jt.SourceXMLFile)
+		compareJSON(t, v2, jt.RefJSONFile)
+		x2, err := v2.ToXML("    ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(x, x2) {
+			t.Errorf("%s: XML changed in round trip:\n%s\n%s", jt.SourceXMLFile, x, x2)
+		}
+	}
+}
+
+func TestXMLStructure(t *testing.T) {
+	for _, c := range []struct {
+		xml      string
+		problems int
+	}{
+		{`<JobUsageRecord xmlns:urwg="http://www.gridforum.org/2003/ur-wg"><RecordIdentity urwg:recordId="r1"/></JobUsageRecord>`, 0},
+		{`<JobUsageRecord><JobName>a</JobName></JobUsageRecord>`, 1},
+		{`<JobUsageRecord xmlns:urwg="http://www.gridforum.org/2003/ur-wg"><RecordIdentity urwg:recordId=""/></JobUsageRecord>`, 1},
+		{`<JobUsageRecord xmlns:urwg="http://www.gridforum.org/2003/ur-wg"><RecordIdentity urwg:recordId="r1"/><EndTime>2016-06-01T12:00:00Z</EndTime><EndTime>2016-06-01T12:00:00Z</EndTime><Status>0</Status><Status>1</Status></JobUsageRecord>`, 2},
+		{`<JobUsageRecord xmlns:urwg="http://www.gridforum.org/2003/ur-wg"><RecordIdentity urwg:recordId="r1"/><CpuDuration urwg:usageType="idle">PT1S</CpuDuration><Memory urwg:metric="median">1</Memory></JobUsageRecord>`, 2},
+		{`<JobUsageRecord xmlns:urwg="http://www.gridforum.org/2003/ur-wg"><RecordIdentity urwg:recordId="r1"/><Memory urwg:phaseUnit="1 hour">1</Memory></JobUsageRecord>`, 1},
+		{`<StorageRecord xmlns:ur="http://schema.ogf.org/urf/2013/04/urf"><StorageSystem>se</StorageSystem></StorageRecord>`, 1},
+		{`<StorageElementRecord><UniqueID>se</UniqueID></StorageElementRecord>`, 0},
+		{`<UsageRecord xmlns="http://eu-emi.eu/namespaces/2012/11/computerecord"><Site>s</Site></UsageRecord>`, 1},
+		{`<StorageUsageRecord xmlns="http://eu-emi.eu/namespaces/2011/02/storagerecord"><RecordIdentity recordId="r1"/></StorageUsageRecord>`, 0},
+	} {
+		v, err := ParseRecordXML([]byte(c.xml))
+		if err != nil {
+			t.Fatalf("%s: %s", c.xml, err)
+		}
+		_, err = v.ToXML("")
+		if c.problems == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", c.xml, err)
+			}
+			continue
+		}
+		if se, ok := err.(*StructureError); !ok {
+			t.Errorf("%s: expected StructureError, got %v", c.xml, err)
+		} else if len(se.Problems) != c.problems {
+			t.Errorf("%s: expected %d problems, got %s", c.xml, c.problems, se)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opensciencegrid/gracc-collector/gracc"
+)
+
+// writeTemp writes files, by name, to a new temporary directory, and returns
+// the directory, which the caller should remove.
+func writeTemp(t *testing.T, prefix string, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// loadRecord parses the record in file, returning it and its raw record.
+func loadRecord(t *testing.T, file string) (gracc.Record, map[string]interface{}) {
+	t.Helper()
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := gracc.ParseRecordXML(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rawRecord(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec, r
+}
+
+// checkFields checks the fields of raw record r against expect, in which nil
+// means the field is absent. Numbers are compared to within 1e-12.
+func checkFields(t *testing.T, name string, r, expect map[string]interface{}) {
+	t.Helper()
+	for k, want := range expect {
+		got, ok := r[k]
+		switch w := want.(type) {
+		case nil:
+			if ok {
+				t.Errorf("%s: unexpected %s %v", name, k, got)
+			}
+		case float64:
+			if g, isNum := numberValue(got); !isNum || math.Abs(g-w) > 1e-12 {
+				t.Errorf("%s: expected %s %v, got %v", name, k, want, got)
+			}
+		default:
+			if got != want {
+				t.Errorf("%s: expected %s %v, got %v", name, k, want, got)
+			}
+		}
+	}
+}
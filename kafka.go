@@ -1,7 +1,7 @@
 package main
 
 import (
-	"encoding/xml"
+	"fmt"
 	"strings"
 
 	"github.com/Shopify/sarama"
@@ -10,10 +10,15 @@ import (
 )
 
 type KafkaConfig struct {
-	Enable  bool   `env:"ENABLE"`
-	Brokers string `env:"BROKERS"`
-	Topic   string `env:"TOPIC"`
-	Format  string `env:"FORMAT"`
+	Enable  bool          `env:"ENABLE"`
+	Brokers string        `env:"BROKERS"`
+	Topic   string        `env:"TOPIC"`
+	Format  string        `env:"FORMAT"`
+	Mapping MappingConfig `env:"GRACC_KAFKA_MAPPING_"`
+}
+
+func (c *KafkaConfig) Validate() error {
+	return c.Mapping.Validate()
 }
 
 type KafkaOutput struct {
@@ -39,7 +44,10 @@ func (k *KafkaOutput) PublishRecord(rec gracc.Record) error {
 	ll := log.WithFields(log.Fields{
 		"where": "KafkaOutput.PublishRecord",
 	})
-	msg := k.makeMessage(rec)
+	msg, err := k.makeMessage(rec)
+	if err != nil {
+		return NewRecordError(fmt.Sprintf("error making Kafka message from %s record %s: %s", rec.Type(), rec.Id(), err))
+	}
 	partition, offset, err := k.producer.SendMessage(msg)
 	if err != nil {
 		ll.WithFields(log.Fields{
@@ -47,18 +55,17 @@ func (k *KafkaOutput) PublishRecord(rec gracc.Record) error {
 			"partition": partition,
 			"offset":    offset,
 		}).Errorf("error sending record: %s", err)
-	} else {
-		ll.WithFields(log.Fields{
-			"topic":     k.Config.Topic,
-			"partition": partition,
-			"offset":    offset,
-		}).Debug("record sent")
-
+		return NewOutputError(fmt.Sprintf("error sending %s record %s to Kafka: %s", rec.Type(), rec.Id(), err))
 	}
+	ll.WithFields(log.Fields{
+		"topic":     k.Config.Topic,
+		"partition": partition,
+		"offset":    offset,
+	}).Debug("record sent")
 	return nil
 }
 
-func (k *KafkaOutput) makeMessage(jur gracc.Record) *sarama.ProducerMessage {
+func (k *KafkaOutput) makeMessage(jur gracc.Record) (*sarama.ProducerMessage, error) {
 	ll := log.WithFields(log.Fields{
 		"where": "KafkaOuput.makePublishing",
 	})
@@ -68,21 +75,37 @@ func (k *KafkaOutput) makeMessage(jur gracc.Record) *sarama.ProducerMessage {
 	case "raw":
 		msg.Value = sarama.ByteEncoder(jur.Raw())
 	case "xml":
-		if j, err := xml.Marshal(jur); err != nil {
-			ll.Error("error converting JobUsageRecord to xml")
+		if j, err := jur.ToXML(""); err != nil {
+			ll.Error("error converting record to xml")
+			ll.Debugf("%v", jur)
+			return nil, err
+		} else {
+			msg.Value = sarama.ByteEncoder(j)
+		}
+	case "ur-json":
+		if j, err := jur.ToURJSON(k.Config.Mapping.indent()); err != nil {
+			ll.Error("error converting record to UR json")
 			ll.Debugf("%v", jur)
-			return nil
+			return nil, err
 		} else {
 			msg.Value = sarama.ByteEncoder(j)
 		}
+	case "protobuf":
+		if b, err := k.Config.Mapping.toProtobuf(jur); err != nil {
+			ll.Error("error converting record to protobuf")
+			ll.Debugf("%v", jur)
+			return nil, err
+		} else {
+			msg.Value = sarama.ByteEncoder(b)
+		}
 	default:
-		if j, err := jur.ToJSON("    "); err != nil {
+		if j, err := k.Config.Mapping.toJSON(jur); err != nil {
 			ll.Error("error converting JobUsageRecord to json")
 			ll.Debugf("%v", jur)
-			return nil
+			return nil, err
 		} else {
 			msg.Value = sarama.ByteEncoder(j)
 		}
 	}
-	return &msg
+	return &msg, nil
 }
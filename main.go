@@ -97,7 +97,9 @@ func main() {
 	// We don't use the DefaultServeMux since pprof registers handlers with it, which we may not want.
 	mux := http.NewServeMux()
 	mux.Handle("/gratia-servlets/rmi", g)
+	mux.HandleFunc("/gratia-servlets/tarball", g.ServeTarball)
 	mux.HandleFunc("/stats", g.ServeStats)
+	mux.HandleFunc("/probes", g.ServeProbes)
 	mux.Handle("/metrics", prometheus.Handler())
 	srv := &http.Server{
 		Addr:         config.Address + ":" + config.Port,
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/opensciencegrid/gracc-collector/gracc"
+	"github.com/opensciencegrid/gracc-collector/gracc/graccpb"
+)
+
+// MappingConfig transforms the raw records sent by an output, after they are
+// converted to JSON.
+//
+// Include and Exclude are comma-separated lists of keys, which may contain
+// shell patterns (e.g. "Resource_*"). If Include is set only matching keys
+// (and "type") are kept, then any keys matching Exclude are dropped. Keys are
+// then renamed according to Rename; no two keys may be renamed to the same key.
+// RawXML is "on" (default), "off" to drop it, or "truncate" to cut it to
+// RawXMLMax bytes (default 1024), in which case "_rawXMLLength" is set to its
+// original length. "_rawXMLLength" is filtered and renamed like any other key.
+// Encoding is "pretty" (default) or "compact", and also applies to the
+// ur-json format.
+type MappingConfig struct {
+	Include   string            `env:"INCLUDE"`
+	Exclude   string            `env:"EXCLUDE"`
+	Rename    map[string]string `env:"-"`
+	RawXML    string            `env:"RAWXML"`
+	RawXMLMax int               `env:"RAWXMLMAX"`
+	Encoding  string            `env:"ENCODING"`
+	include   []string
+	exclude   []string
+}
+
+const (
+	RawXMLOn       = "on"
+	RawXMLOff      = "off"
+	RawXMLTruncate = "truncate"
+
+	EncodingPretty  = "pretty"
+	EncodingCompact = "compact"
+)
+
+func (c *MappingConfig) Validate() error {
+	c.include = splitPatterns(c.Include)
+	c.exclude = splitPatterns(c.Exclude)
+	for _, p := range append(c.include, c.exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("bad Mapping pattern %q: %s", p, err)
+		}
+	}
+	var from []string
+	for k := range c.Rename {
+		from = append(from, k)
+	}
+	sort.Strings(from)
+	var renamed = make(map[string]string)
+	for _, k := range from {
+		n := c.Rename[k]
+		if o, ok := renamed[n]; ok {
+			return fmt.Errorf("Mapping Rename of both %q and %q to %q", o, k, n)
+		}
+		renamed[n] = k
+	}
+	switch c.RawXML {
+	case "":
+		c.RawXML = RawXMLOn
+	case RawXMLOn, RawXMLOff, RawXMLTruncate:
+	default:
+		return fmt.Errorf("unknown Mapping RawXML value %q", c.RawXML)
+	}
+	if c.RawXMLMax <= 0 {
+		c.RawXMLMax = 1024
+	}
+	switch c.Encoding {
+	case "":
+		c.Encoding = EncodingPretty
+	case EncodingPretty, EncodingCompact:
+	default:
+		return fmt.Errorf("unknown Mapping Encoding value %q", c.Encoding)
+	}
+	return nil
+}
+
+// splitPatterns returns the patterns in the comma-separated list s.
+func splitPatterns(s string) []string {
+	var ps []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+func matchAny(patterns []string, k string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, k); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// indent returns the indent to use for JSON encoding.
+func (c *MappingConfig) indent() string {
+	if c.Encoding == EncodingCompact {
+		return ""
+	}
+	return "    "
+}
+
+// identity returns true if the mapping does not change records.
+func (c *MappingConfig) identity() bool {
+	return len(c.include) == 0 && len(c.exclude) == 0 && len(c.Rename) == 0 &&
+		(c.RawXML == "" || c.RawXML == RawXMLOn)
+}
+
+// toMap returns the raw record for rec, with the mapping applied.
+// Numbers are json.Numbers, so they are re-encoded exactly.
+func (c *MappingConfig) toMap(rec gracc.Record) (map[string]interface{}, error) {
+	r, err := rawRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+	return c.apply(r), nil
+}
+
+// apply applies the mapping to the raw record r, returning a new map.
+func (c *MappingConfig) apply(r map[string]interface{}) map[string]interface{} {
+	var m = make(map[string]interface{}, len(r))
+	for k, v := range r {
+		if !c.keep(k) {
+			continue
+		}
+		if k == "RawXML" {
+			switch c.RawXML {
+			case RawXMLOff:
+				continue
+			case RawXMLTruncate:
+				if x, ok := v.(string); ok && len(x) > c.RawXMLMax {
+					if c.keep("_rawXMLLength") {
+						m[c.rename("_rawXMLLength")] = len(x)
+					}
+					v = truncateUTF8(x, c.RawXMLMax)
+				}
+			}
+		}
+		m[c.rename(k)] = v
+	}
+	return m
+}
+
+// keep returns true if key k is kept by Include and Exclude.
+func (c *MappingConfig) keep(k string) bool {
+	if len(c.include) > 0 && k != "type" && !matchAny(c.include, k) {
+		return false
+	}
+	return !matchAny(c.exclude, k)
+}
+
+// rename returns the new name of key k.
+func (c *MappingConfig) rename(k string) string {
+	if n, ok := c.Rename[k]; ok {
+		return n
+	}
+	return k
+}
+
+// truncateUTF8 truncates s to at most n bytes, without splitting a character.
+func truncateUTF8(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// toJSON returns the JSON encoding of rec, with the mapping applied.
+func (c *MappingConfig) toJSON(rec gracc.Record) ([]byte, error) {
+	if c.identity() {
+		return rec.ToJSON(c.indent())
+	}
+	r, err := c.toMap(rec)
+	if err != nil {
+		return nil, err
+	}
+	if i := c.indent(); i != "" {
+		return json.MarshalIndent(r, "", i)
+	}
+	return json.Marshal(r)
+}
+
+// toProtobuf returns the protobuf encoding of rec, with the mapping applied.
+func (c *MappingConfig) toProtobuf(rec gracc.Record) ([]byte, error) {
+	r, err := c.toMap(rec)
+	if err != nil {
+		return nil, err
+	}
+	return graccpb.MarshalMap(r)
+}
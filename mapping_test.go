@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/opensciencegrid/gracc-collector/gracc/graccpb"
+)
+
+func TestMapping(t *testing.T) {
+	rec, _ := loadRecord(t, "gracc/test_data/StorageElementRecord01.xml")
+	raw := string(rec.Raw())
+
+	for _, tc := range []struct {
+		mapping MappingConfig
+		expect  map[string]interface{} // expected values; nil means absent
+	}{
+		{
+			MappingConfig{},
+			map[string]interface{}{"UniqueID": "UMN-CMS-SE:SE:UMN-CMS-SE", "RawXML": raw},
+		},
+		{
+			MappingConfig{Include: "UniqueID, *Space"},
+			map[string]interface{}{"type": "StorageElementRecord", "UniqueID": "UMN-CMS-SE:SE:UMN-CMS-SE",
+				"TotalSpace": 158961960684544.0, "FileCount": nil, "RawXML": nil},
+		},
+		{
+			MappingConfig{Exclude: "Origin*,FileCount", Rename: map[string]string{"UniqueID": "id"}},
+			map[string]interface{}{"id": "UMN-CMS-SE:SE:UMN-CMS-SE", "UniqueID": nil, "OriginSender": nil,
+				"Origin_hop": nil, "FileCount": nil, "FileCountLimit": 2147483647.0, "RawXML": raw},
+		},
+		{
+			MappingConfig{RawXML: RawXMLOff},
+			map[string]interface{}{"UniqueID": "UMN-CMS-SE:SE:UMN-CMS-SE", "RawXML": nil},
+		},
+		{
+			MappingConfig{RawXML: RawXMLTruncate, RawXMLMax: 10},
+			map[string]interface{}{"RawXML": raw[:10], "_rawXMLLength": float64(len(raw))},
+		},
+		{
+			MappingConfig{RawXML: RawXMLTruncate, RawXMLMax: 10, Rename: map[string]string{"_rawXMLLength": "RawXMLLength"}},
+			map[string]interface{}{"RawXML": raw[:10], "_rawXMLLength": nil, "RawXMLLength": float64(len(raw))},
+		},
+		{
+			MappingConfig{RawXML: RawXMLTruncate, RawXMLMax: 10, Exclude: "_*"},
+			map[string]interface{}{"RawXML": raw[:10], "_rawXMLLength": nil},
+		},
+		{
+			MappingConfig{RawXML: RawXMLTruncate, RawXMLMax: 10, Include: "UniqueID"},
+			map[string]interface{}{"UniqueID": "UMN-CMS-SE:SE:UMN-CMS-SE", "RawXML": nil, "_rawXMLLength": nil},
+		},
+	} {
+		if err := tc.mapping.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		j, err := tc.mapping.toJSON(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r map[string]interface{}
+		if err := json.Unmarshal(j, &r); err != nil {
+			t.Fatal(err)
+		}
+		checkFields(t, fmt.Sprintf("%+v", tc.mapping), r, tc.expect)
+	}
+
+	// encoding
+	m := MappingConfig{Encoding: EncodingCompact, RawXML: RawXMLOff}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	j, err := m.toJSON(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(j, []byte("\n")) {
+		t.Errorf("compact encoding contains newlines: %s", j)
+	}
+	if !strings.Contains(string(j), `"TotalSpace":158961960684544`) {
+		t.Errorf("numbers changed: %s", j)
+	}
+
+	// protobuf
+	m = MappingConfig{RawXML: RawXMLOff, Exclude: "Origin*"}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.toProtobuf(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pb graccpb.Record
+	if err := proto.Unmarshal(b, &pb); err != nil {
+		t.Fatal(err)
+	}
+	ser := pb.GetStorageElementRecord()
+	if ser == nil || ser.TotalSpace != 158961960684544 || len(ser.RawXml) > 0 || len(ser.Extensions) > 0 {
+		t.Errorf("unexpected protobuf record: %s", &pb)
+	}
+}
+
+func TestMappingValidate(t *testing.T) {
+	for _, m := range []MappingConfig{
+		{RawXML: "maybe"},
+		{Encoding: "tabs"},
+		{Include: "Resource_["},
+		{Rename: map[string]string{"SiteName": "site", "Site": "site"}},
+	} {
+		if err := m.Validate(); err == nil {
+			t.Errorf("%+v: expected error", m)
+		}
+	}
+}
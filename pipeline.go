@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/opensciencegrid/gracc-collector/gracc"
+)
+
+// A stage processes the raw records of each bundle after it is parsed, before
+// the records are sent to the outputs. Stages that also implement
+// prometheus.Collector are collected with the collector metrics.
+type stage interface {
+	// process processes raw record r of rec, which it may modify. It returns
+	// false if the record is to be dropped, or an error if the whole bundle
+	// is to be rejected.
+	process(rec gracc.Record, r map[string]interface{}) (bool, error)
+}
+
+// stageFormats are the output formats that are encoded from the processed
+// raw record, and so include the results of the stages. The other formats
+// (raw, xml and ur-json) are encoded from the original record.
+var stageFormats = map[string]bool{
+	"json":     true,
+	"protobuf": true,
+}
+
+// checkStageFormats returns an error if an enabled output of conf uses a
+// format that does not include the results of the stages.
+func checkStageFormats(conf *CollectorConfig) error {
+	for _, o := range []struct {
+		name   string
+		enable bool
+		format string
+	}{
+		{"AMQP", conf.AMQP.Enable, conf.AMQP.Format},
+		{"Kafka", conf.Kafka.Enable, conf.Kafka.Format},
+	} {
+		if o.enable && !stageFormats[o.format] {
+			return fmt.Errorf("%s format %q does not include the results of record processing "+
+				"(time checks, topology, VO mapping, benchmarks, rate cards and validation); use json or protobuf",
+				o.name, o.format)
+		}
+	}
+	return nil
+}
+
+// processedRecord is a record whose raw record has been processed by the
+// stages. Its JSON encoding is the processed raw record; other encodings are
+// those of the original record, so only formats in stageFormats may be used.
+type processedRecord struct {
+	gracc.Record
+	raw map[string]interface{}
+}
+
+func (p *processedRecord) ToJSON(indent string) ([]byte, error) {
+	if indent != "" {
+		return json.MarshalIndent(p.raw, "", indent)
+	}
+	return json.Marshal(p.raw)
+}
+
+// rawRecord returns the raw record of rec, as decoded from its JSON encoding.
+// Numbers are json.Numbers, so they are re-encoded exactly. The raw record of a
+// processedRecord is returned as is, and must not be modified.
+func rawRecord(rec gracc.Record) (map[string]interface{}, error) {
+	if p, ok := rec.(*processedRecord); ok {
+		return p.raw, nil
+	}
+	j, err := rec.ToJSON("")
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	var r map[string]interface{}
+	if err := d.Decode(&r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// processRecords passes recs[i], for each i in idx, through the stages,
+// returning the records to send, which are nil if the record is not in idx
+// or was dropped.
+func (g *GraccCollector) processRecords(recs []gracc.Record, idx []int) ([]gracc.Record, error) {
+	var out = make([]gracc.Record, len(recs))
+	if len(g.stages) == 0 {
+		for _, i := range idx {
+			out[i] = recs[i]
+		}
+		return out, nil
+	}
+RecordLoop:
+	for _, i := range idx {
+		rec := recs[i]
+		r, err := rawRecord(rec)
+		if err != nil {
+			return nil, NewRecordError(fmt.Sprintf("error converting %s record %s: %s", rec.Type(), rec.Id(), err))
+		}
+		for _, s := range g.stages {
+			keep, err := s.process(rec, r)
+			if err != nil {
+				return nil, err
+			}
+			if !keep {
+				log.WithFields(log.Fields{
+					"type": rec.Type(),
+					"id":   rec.Id(),
+				}).Debug("dropping rejected record")
+				continue RecordLoop
+			}
+		}
+		out[i] = &processedRecord{rec, r}
+	}
+	return out, nil
+}
+
+// firstString returns the first of raw record fields keys that is a
+// non-empty string.
+func firstString(r map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		if s, _ := r[k].(string); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// tagRecord adds msg to the "_validation" field of raw record r.
+func tagRecord(r map[string]interface{}, msg string) {
+	tags, _ := r["_validation"].([]string)
+	r["_validation"] = append(tags, msg)
+}
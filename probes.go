@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/opensciencegrid/gracc-collector/gracc"
+)
+
+// ProbeInfo is the software reported by a probe in its most recent ProbeDetails.
+type ProbeInfo struct {
+	ProbeName string
+	SiteName  string
+	Versions  map[string]string
+	LastSeen  time.Time
+}
+
+// ProbeRegistry keeps track of the software versions reported by each probe.
+type ProbeRegistry struct {
+	probes map[string]ProbeInfo
+	m      sync.Mutex
+}
+
+func NewProbeRegistry() *ProbeRegistry {
+	return &ProbeRegistry{
+		probes: make(map[string]ProbeInfo),
+	}
+}
+
+// Update records the software versions in pd.
+func (p *ProbeRegistry) Update(pd *gracc.ProbeDetails) {
+	if pd.ProbeName == "" {
+		log.WithField("record", pd.Id()).Debug("ProbeDetails has no ProbeName; ignoring")
+		return
+	}
+	p.m.Lock()
+	p.probes[pd.ProbeName] = ProbeInfo{
+		ProbeName: pd.ProbeName,
+		SiteName:  pd.SiteName,
+		Versions:  pd.Versions(),
+		LastSeen:  time.Now(),
+	}
+	p.m.Unlock()
+}
+
+// Probes returns a copy of the info for all known probes, keyed by ProbeName.
+func (p *ProbeRegistry) Probes() map[string]ProbeInfo {
+	p.m.Lock()
+	defer p.m.Unlock()
+	var probes = make(map[string]ProbeInfo, len(p.probes))
+	for k, v := range p.probes {
+		probes[k] = v
+	}
+	return probes
+}
+
+func (g *GraccCollector) ServeProbes(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(g.Probes.Probes()); err != nil {
+		log.WithField("err", err).Error("error encoding probes")
+		http.Error(w, "error writing probes", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensciencegrid/gracc-collector/gracc"
+)
+
+// RateCardConfig configures the computation of charges for JobUsageRecords
+// and StorageElementRecords, from the JSON rate card File. The file is
+// checked for changes every Interval.
+type RateCardConfig struct {
+	File             string        `env:"FILE"`
+	Interval         string        `env:"INTERVAL"`
+	IntervalDuration time.Duration `env:"-"`
+}
+
+func (c *RateCardConfig) Validate() error {
+	return parseInterval("RateCard", &c.Interval, &c.IntervalDuration)
+}
+
+// rateCard is the contents of a rate card file.
+type rateCard struct {
+	Version string
+	Rates   []rate
+}
+
+// rate is the charge per core-hour, GPU-hour and TB-month (of used space) of
+// records whose site, ResourceType and VO match Site, ResourceType and VO.
+// These are shell patterns (see path.Match), and empty patterns match
+// anything. The site is the Site set from the topology, or the SiteName if
+// there is none, and the VO is the ReportableVOName, or the VOName if there is
+// none, matched regardless of case. A rate of 0 is no rate.
+type rate struct {
+	Site         string
+	ResourceType string
+	VO           string
+	CoreHour     float64
+	GPUHour      float64
+	TBMonth      float64
+	Currency     string
+}
+
+// appliesTo returns true if the rate has a rate for records of type t:
+// CoreHour or GPUHour for JobUsageRecords, and TBMonth for
+// StorageElementRecords.
+func (e *rate) appliesTo(t interface{}) bool {
+	switch t {
+	case "JobUsageRecord":
+		return e.CoreHour != 0 || e.GPUHour != 0
+	case "StorageElementRecord":
+		return e.TBMonth != 0
+	}
+	return false
+}
+
+func (e *rate) matches(r map[string]interface{}) bool {
+	if !e.appliesTo(r["type"]) {
+		return false
+	}
+	for _, m := range []struct{ p, s string }{
+		{e.Site, firstString(r, "Site", "SiteName")},
+		{e.ResourceType, firstString(r, "ResourceType")},
+		{strings.ToLower(e.VO), strings.ToLower(firstString(r, "ReportableVOName", "VOName"))},
+	} {
+		if m.p == "" {
+			continue
+		}
+		if ok, _ := path.Match(m.p, m.s); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// loadRateCard reads the rate card from file.
+func loadRateCard(file string) (*rateCard, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rc rateCard
+	if err := json.Unmarshal(buf, &rc); err != nil {
+		return nil, fmt.Errorf("error parsing rate card file %s: %s", file, err)
+	}
+	if rc.Version == "" {
+		return nil, fmt.Errorf("rate card file %s has no version", file)
+	}
+	for i, e := range rc.Rates {
+		for _, p := range []string{e.Site, e.ResourceType, e.VO} {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("rate card file %s rate %d: bad pattern %q", file, i+1, p)
+			}
+		}
+		if e.CoreHour < 0 || e.GPUHour < 0 || e.TBMonth < 0 {
+			return nil, fmt.Errorf("rate card file %s rate %d: negative rate", file, i+1)
+		}
+	}
+	return &rc, nil
+}
+
+// rateCards is the stage that adds charges computed from the rate card.
+type rateCards struct {
+	watchedFile
+	card *rateCard
+	cm   sync.RWMutex
+}
+
+// newRateCards loads the rate card file, and starts watching it for changes.
+func newRateCards(conf RateCardConfig) (*rateCards, error) {
+	var c = &rateCards{}
+	c.watchedFile = watchedFile{file: conf.File, load: c.load}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	c.watch(conf.IntervalDuration)
+	return c, nil
+}
+
+func (c *rateCards) load(file string) error {
+	rc, err := loadRateCard(file)
+	if err != nil {
+		return err
+	}
+	c.cm.Lock()
+	c.card = rc
+	c.cm.Unlock()
+	return nil
+}
+
+// lookup returns the first rate that matches raw record r and has a rate for
+// its type, or nil if there is none, and the rate card version.
+func (c *rateCards) lookup(r map[string]interface{}) (*rate, string) {
+	c.cm.RLock()
+	defer c.cm.RUnlock()
+	for i := range c.card.Rates {
+		if c.card.Rates[i].matches(r) {
+			e := c.card.Rates[i]
+			return &e, c.card.Version
+		}
+	}
+	return nil, c.card.Version
+}
+
+// process adds the charge computed from the first matching rate for the
+// record type to JobUsageRecords and StorageElementRecords, as ComputedCharge,
+// with the currency, rate card version, and formula used. Records with no
+// matching rate are left as they are. Any Charge reported by the probe is
+// kept as is.
+//
+// For JobUsageRecords the charge is for the CoreHours (see gracc.CoreHours)
+// and GPUHours. For StorageElementRecords it is the charge per month for the
+// UsedSpace, in TB (10^12 bytes).
+func (c *rateCards) process(rec gracc.Record, r map[string]interface{}) (bool, error) {
+	switch r["type"] {
+	case "JobUsageRecord", "StorageElementRecord":
+	default:
+		return true, nil
+	}
+	e, version := c.lookup(r)
+	if e == nil {
+		return true, nil
+	}
+	if r["type"] == "StorageElementRecord" {
+		used, ok := numberValue(r["UsedSpace"])
+		if !ok {
+			return true, nil
+		}
+		setCharge(r, used/1e12*e.TBMonth, e.Currency, version,
+			"UsedSpace(TB)*"+formatRate(e.TBMonth)+" per month")
+		return true, nil
+	}
+	coreHours := gracc.CoreHours(r)
+	gpuHours, _ := numberValue(r["GPUHours"])
+	formula := "CoreHours*" + formatRate(e.CoreHour)
+	if e.GPUHour != 0 {
+		formula += " + GPUHours*" + formatRate(e.GPUHour)
+	}
+	setCharge(r, coreHours*e.CoreHour+gpuHours*e.GPUHour, e.Currency, version, formula)
+	return true, nil
+}
+
+func formatRate(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// setCharge sets the computed charge fields of raw record r.
+func setCharge(r map[string]interface{}, charge float64, currency, version, formula string) {
+	r["ComputedCharge"] = charge
+	if currency != "" {
+		r["ComputedCharge_currency"] = currency
+	}
+	r["ComputedCharge_rateVersion"] = version
+	r["ComputedCharge_formula"] = formula
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRateCard(t *testing.T) {
+	dir := writeTemp(t, "gracc-ratecard", map[string]string{"ratecard.json": `{
+		"version": "2026-10",
+		"rates": [
+			{"site": "TAMU_*", "vo": "cms", "coreHour": 0.01, "gpuHour": 0.25, "currency": "USD"},
+			{"site": "UCSDT2-*", "resourceType": "Batch", "coreHour": 0.02},
+			{"tbMonth": 5, "currency": "USD"}
+		]
+	}`})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ratecard.json")
+	conf := RateCardConfig{File: file}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := newRateCards(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+
+	for _, tc := range []struct {
+		file   string
+		set    map[string]interface{} // fields set before processing
+		expect map[string]interface{} // expected values; nil means absent
+	}{
+		{"gracc/test_data/JobUsageRecord04.xml", nil, map[string]interface{}{
+			"ComputedCharge": 21 / 3600.0 * 0.01, "ComputedCharge_currency": "USD",
+			"ComputedCharge_rateVersion": "2026-10", "ComputedCharge_formula": "CoreHours*0.01 + GPUHours*0.25"}},
+		// Site is matched before SiteName, and the storage-only rate does
+		// not apply to jobs
+		{"gracc/test_data/JobUsageRecord04.xml", map[string]interface{}{"Site": "TAMU"}, map[string]interface{}{
+			"ComputedCharge": nil, "ComputedCharge_formula": nil}},
+		{"gracc/test_data/JobUsageRecord10.xml", map[string]interface{}{"GPUHours": 4.0}, map[string]interface{}{
+			"ComputedCharge": 16*0.01 + 4*0.25}},
+		{"gracc/test_data/JobUsageRecord06.xml", map[string]interface{}{"CoreHours": 2.0}, map[string]interface{}{
+			"ComputedCharge": 2 * 0.02, "ComputedCharge_currency": nil, "ComputedCharge_formula": "CoreHours*0.02"}},
+		{"gracc/test_data/JobUsageRecord02.xml", nil, map[string]interface{}{
+			"ComputedCharge": nil, "Charge": "2870"}},
+		{"gracc/test_data/StorageElementRecord01.xml", nil, map[string]interface{}{
+			"ComputedCharge": 125757757218816 / 1e12 * 5, "ComputedCharge_formula": "UsedSpace(TB)*5 per month"}},
+		// the compute-only rate does not apply to storage
+		{"gracc/test_data/StorageElementRecord01.xml", map[string]interface{}{"Site": "UCSDT2-B", "ResourceType": "Batch"}, map[string]interface{}{
+			"ComputedCharge": 125757757218816 / 1e12 * 5, "ComputedCharge_formula": "UsedSpace(TB)*5 per month"}},
+		{"gracc/test_data/StorageElement01.xml", nil, map[string]interface{}{
+			"ComputedCharge": nil}},
+	} {
+		rec, r := loadRecord(t, tc.file)
+		for k, v := range tc.set {
+			r[k] = v
+		}
+		if keep, err := c.process(rec, r); !keep || err != nil {
+			t.Fatalf("%s: record rejected: %v", tc.file, err)
+		}
+		checkFields(t, tc.file, r, tc.expect)
+	}
+
+	for _, bad := range []string{
+		`{"rates": [{"coreHour": 1}]}`,
+		`{"version": "1", "rates": [{"vo": "[", "coreHour": 1}]}`,
+		`{"version": "1", "rates": [{"coreHour": -1}]}`,
+		`[{"coreHour": 1}]`,
+	} {
+		if err := ioutil.WriteFile(file, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadRateCard(file); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
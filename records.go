@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/opensciencegrid/gracc-collector/gracc"
+)
+
+type RecordsConfig struct {
+	PassThrough bool                 `env:"PASSTHROUGH"`
+	Allow       string               `env:"ALLOW"`
+	Deny        string               `env:"DENY"`
+	Repeated    string               `env:"REPEATED"`
+	Namespaces  map[string]string    `env:"-"`
+	Numeric     bool                 `env:"NUMERIC"`
+	ToBytes     bool                 `env:"TOBYTES"`
+	Durations   string               `env:"DURATIONS"`
+	Classify    []gracc.ClassifyRule `env:"-"`
+	Derived     bool                 `env:"DERIVED"`
+	GPU         []gracc.GPURule      `env:"-"`
+	allow       map[string]bool
+	deny        map[string]bool
+}
+
+func (c *RecordsConfig) Validate() error {
+	c.allow = splitTypes(c.Allow)
+	c.deny = splitTypes(c.Deny)
+	switch c.Repeated {
+	case "", gracc.RepeatedOverwrite, gracc.RepeatedArray, gracc.RepeatedSuffix:
+	default:
+		return fmt.Errorf("unknown Records Repeated value %q", c.Repeated)
+	}
+	switch c.Durations {
+	case "", gracc.DurationsLenient, gracc.DurationsStrict:
+	default:
+		return fmt.Errorf("unknown Records Durations value %q", c.Durations)
+	}
+	return nil
+}
+
+// jsonOptions returns the options for converting records to JSON.
+func (c *RecordsConfig) jsonOptions() gracc.Options {
+	return gracc.Options{
+		Repeated:       c.Repeated,
+		Namespaces:     c.Namespaces,
+		NumericValues:  c.Numeric,
+		NormalizeUnits: c.ToBytes,
+		Durations:      c.Durations,
+		Classify:       c.Classify,
+		Derived:        c.Derived,
+		GPU:            c.GPU,
+	}
+}
+
+// splitTypes returns the set of record types in the comma-separated list s.
+func splitTypes(s string) map[string]bool {
+	var m map[string]bool
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		if m == nil {
+			m = make(map[string]bool)
+		}
+		m[t] = true
+	}
+	return m
+}
+
+// Accept returns true if records of type t should be published.
+// If an Allow list is configured only those types are accepted,
+// otherwise all types that are not in the Deny list are accepted.
+func (c *RecordsConfig) Accept(t string) bool {
+	if c.allow != nil {
+		return c.allow[t]
+	}
+	return !c.deny[t]
+}
+
+// parseRecordXML parses a single record. If PassThrough is enabled,
+// records of unrecognized type are returned as a gracc.GenericRecord.
+func (g *GraccCollector) parseRecordXML(buf []byte) (gracc.Record, error) {
+	rec, err := gracc.ParseRecordXML(buf)
+	if _, ok := err.(*gracc.DurationError); ok {
+		return nil, err
+	}
+	if err != nil && g.Config.Records.PassThrough {
+		if grec, gerr := gracc.ParseGenericRecordXML(buf); gerr == nil {
+			return grec, nil
+		}
+	}
+	return rec, err
+}
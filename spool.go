@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type SpoolConfig struct {
+	Enable           bool          `env:"ENABLE"`
+	Dirs             string        `env:"DIRS"`
+	Pattern          string        `env:"PATTERN"`
+	Interval         string        `env:"INTERVAL"`
+	IntervalDuration time.Duration `env:"-"`
+}
+
+func (c *SpoolConfig) Validate() error {
+	if c.Pattern == "" {
+		c.Pattern = "*.xml"
+	}
+	if c.Interval == "" {
+		c.Interval = "10s"
+	}
+	var err error
+	c.IntervalDuration, err = time.ParseDuration(c.Interval)
+	if err != nil {
+		return fmt.Errorf("error parsing Spool Interval: %s", err)
+	}
+	if c.Enable && strings.TrimSpace(c.Dirs) == "" {
+		return fmt.Errorf("no spool directories configured")
+	}
+	return nil
+}
+
+// SpoolInput watches one or more spool directories for record files, which
+// are processed the same as a record bundle received over HTTP.
+//
+// Only files matching Pattern (default "*.xml") are processed, and hidden files
+// are ignored, so writers should write to a hidden or non-matching file and
+// rename it when complete. Files may contain a single record, an XML record
+// bundle, or a replication bundle.
+// Each file is claimed by atomically moving it into a work directory specific
+// to this host, so multiple collectors can share a spool directory.
+// After processing, files are moved to "done/", or if they could not be read
+// or parsed, or were rejected, to "failed/" along with a ".err" file containing
+// the error message. Files that could not be published due to an output error
+// are left in the work directory, and are retried before any new files are
+// claimed. The same goes for files left in
+// the work directory if the collector crashed.
+type SpoolInput struct {
+	Config    SpoolConfig
+	Dirs      []string
+	collector *GraccCollector
+	workName  string
+}
+
+func InitSpool(conf SpoolConfig, g *GraccCollector) (*SpoolInput, error) {
+	log.Info("initializing spool input")
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("error getting hostname: %s", err)
+	}
+	var s = &SpoolInput{
+		Config:    conf,
+		collector: g,
+		workName:  host,
+	}
+	for _, d := range strings.Split(conf.Dirs, ",") {
+		if d = strings.TrimSpace(d); d == "" {
+			continue
+		}
+		for _, sub := range []string{s.workDir(d), s.doneDir(d), s.failedDir(d)} {
+			if err := os.MkdirAll(sub, 0755); err != nil {
+				return nil, fmt.Errorf("error creating spool directory: %s", err)
+			}
+		}
+		s.Dirs = append(s.Dirs, d)
+	}
+	return s, nil
+}
+
+func (s *SpoolInput) workDir(dir string) string {
+	return filepath.Join(dir, "work", s.workName)
+}
+
+func (s *SpoolInput) doneDir(dir string) string {
+	return filepath.Join(dir, "done")
+}
+
+func (s *SpoolInput) failedDir(dir string) string {
+	return filepath.Join(dir, "failed")
+}
+
+// Run scans the spool directories every Interval. It does not return.
+func (s *SpoolInput) Run() {
+	for {
+		for _, d := range s.Dirs {
+			s.scan(d)
+		}
+		time.Sleep(s.Config.IntervalDuration)
+	}
+}
+
+// scan retries the files in the work directory of dir, then claims and
+// processes all record files currently in dir. It stops at the first file that
+// has to be retried, since the outputs are likely unavailable.
+func (s *SpoolInput) scan(dir string) {
+	pending, err := ioutil.ReadDir(s.workDir(dir))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"dir":   dir,
+			"error": err,
+		}).Error("spool: error listing work directory")
+		return
+	}
+	for _, f := range pending {
+		log.WithFields(log.Fields{
+			"dir":  dir,
+			"file": f.Name(),
+		}).Info("spool: retrying file")
+		if !s.process(dir, f.Name()) {
+			return
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, s.Config.Pattern))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"dir":   dir,
+			"error": err,
+		}).Error("spool: error listing files")
+		return
+	}
+	for _, f := range files {
+		name := filepath.Base(f)
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		work := filepath.Join(s.workDir(dir), name)
+		if _, err := os.Lstat(work); err == nil {
+			// a file of the same name is still pending; don't overwrite it
+			continue
+		}
+		if err := os.Rename(f, work); err != nil {
+			// most likely claimed by another collector
+			log.WithFields(log.Fields{
+				"file":  f,
+				"error": err,
+			}).Debug("spool: unable to claim file")
+			continue
+		}
+		if !s.process(dir, name) {
+			return
+		}
+	}
+}
+
+// process publishes a claimed file, then moves it out of the work
+// directory according to the result. It returns false if the file was left in
+// the work directory to be retried.
+func (s *SpoolInput) process(dir, name string) bool {
+	ll := log.WithFields(log.Fields{
+		"dir":  dir,
+		"file": name,
+	})
+	work := filepath.Join(s.workDir(dir), name)
+	n, err := s.publishFile(work)
+	switch err.(type) {
+	case nil:
+		ll.WithField("records", n).Info("spool: processed file")
+		if err := os.Rename(work, filepath.Join(s.doneDir(dir), name)); err != nil {
+			ll.WithField("error", err).Error("spool: error moving file to done")
+		}
+	case RecordError, RequestError:
+		ll.WithField("error", err).Error("spool: error processing file")
+		if err := ioutil.WriteFile(filepath.Join(s.failedDir(dir), name+".err"), []byte(err.Error()+"\n"), 0644); err != nil {
+			ll.WithField("error", err).Error("spool: error writing error file")
+		}
+		if err := os.Rename(work, filepath.Join(s.failedDir(dir), name)); err != nil {
+			ll.WithField("error", err).Error("spool: error moving file to failed")
+		}
+	default:
+		ll.WithField("error", err).Error("spool: error sending records; will retry")
+		return false
+	}
+	return true
+}
+
+// publishFile parses and publishes the records in the file at path,
+// returning the number of records in the file.
+func (s *SpoolInput) publishFile(path string) (int, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, NewRecordError(fmt.Sprintf("error reading file: %s", err))
+	}
+	bun, err := s.collector.processRecordFile(buf)
+	if err != nil {
+		s.collector.Events <- GOT_RECORD
+		s.collector.Events <- RECORD_ERROR
+		return 0, err
+	}
+	return bun.RecordCount(), s.collector.sendBundle(bun)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gracc-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := SpoolConfig{
+		Enable:   true,
+		Dirs:     dir,
+		Interval: "1s",
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := InitSpool(conf, collector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"bundle.xml":     testBundleXML,
+		"bad.xml":        "<JobUsageRecord>",
+		".partial.xml":   testBundleXML,
+		"replication.gz": testBundle,
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// left in the work directory, e.g. by an output error
+	if err := ioutil.WriteFile(filepath.Join(s.workDir(dir), "leftover.xml"), []byte(testBundleXML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.scan(dir)
+	for _, f := range []string{
+		filepath.Join(dir, "done", "bundle.xml"),
+		filepath.Join(dir, "done", "leftover.xml"),
+		filepath.Join(dir, "failed", "bad.xml"),
+		filepath.Join(dir, "failed", "bad.xml.err"),
+		filepath.Join(dir, ".partial.xml"),
+		filepath.Join(dir, "replication.gz"),
+	} {
+		if _, err := os.Stat(f); err != nil {
+			t.Error(err)
+		}
+	}
+}
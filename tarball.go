@@ -0,0 +1,257 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/opensciencegrid/gracc-collector/gracc"
+)
+
+// FileSummary reports the outcome of processing a single file
+// from an uploaded tarball. Dropped records are those of denied
+// types, or dropped by record processing.
+type FileSummary struct {
+	File     string `json:"file"`
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	Dropped  int    `json:"dropped"`
+	Error    string `json:"error,omitempty"`
+}
+
+// TarballSummary is the response to a tarball upload.
+type TarballSummary struct {
+	Files    []*FileSummary `json:"files"`
+	Accepted int            `json:"accepted"`
+	Rejected int            `json:"rejected"`
+	Dropped  int            `json:"dropped"`
+}
+
+// tarballChunk accumulates records from one or more files until
+// there are enough to publish, with the file each came from.
+type tarballChunk struct {
+	recs       []gracc.Record
+	recFiles   []*FileSummary
+	other      []gracc.XMLRecord
+	otherFiles []*FileSummary
+}
+
+// ServeTarball handles the upload of a tar (optionally gzipped) archive of
+// record files, as kept by a Gratia probe in its outbox backlog. Each file may
+// contain a single record, an XML record bundle, or a replication bundle.
+// Records are published in chunks of Config.TarballChunkSize, and a JSON
+// summary of accepted and rejected records per file is returned.
+//
+// The archive may be sent either as the request body, or as the form file "file"
+// in a multipart form.
+func (g *GraccCollector) ServeTarball(w http.ResponseWriter, r *http.Request) {
+	g.Events <- GOT_REQUEST
+	req := newRequest(w, r)
+	if r.Method != "POST" {
+		g.Events <- REQUEST_ERROR
+		g.handleError(req, NewRequestError("tarball must be sent by POST"))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, g.Config.MaxUploadSize)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			g.Events <- REQUEST_ERROR
+			g.handleError(req, NewRequestError(fmt.Sprintf("error reading form file: %s", err)))
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+	tr, err := openTarball(body)
+	if err != nil {
+		g.Events <- REQUEST_ERROR
+		g.handleError(req, NewRequestError(err.Error()))
+		return
+	}
+	summary, err := g.processTarball(tr)
+	if err != nil {
+		g.Events <- REQUEST_ERROR
+		g.handleError(req, err)
+		return
+	}
+	req.log.WithFields(log.Fields{
+		"files":    len(summary.Files),
+		"accepted": summary.Accepted,
+		"rejected": summary.Rejected,
+	}).Info("received tarball")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		req.log.WithField("error", err).Error("error encoding tarball summary")
+	}
+	req.log.WithFields(log.Fields{
+		"response":      "OK",
+		"response-code": 200,
+		"response-time": time.Since(req.start).Nanoseconds(),
+	}).Info("handled request")
+}
+
+// openTarball returns a tar reader for r, decompressing it first
+// if it is gzipped.
+func openTarball(r io.Reader) (*tar.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tarball: %s", err)
+	}
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzipped tarball: %s", err)
+		}
+		return tar.NewReader(gr), nil
+	}
+	return tar.NewReader(br), nil
+}
+
+// processTarball parses and publishes all record files in tr. An error is
+// only returned if the archive itself can not be read; errors with individual
+// files and records are recorded in the summary.
+func (g *GraccCollector) processTarball(tr *tar.Reader) (*TarballSummary, error) {
+	var summary TarballSummary
+	chunk := &tarballChunk{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, NewRequestError(fmt.Sprintf("error reading tarball: %s", err))
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		fs := &FileSummary{File: hdr.Name}
+		summary.Files = append(summary.Files, fs)
+		buf, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, NewRequestError(fmt.Sprintf("error reading %s from tarball: %s", hdr.Name, err))
+		}
+		bun, err := g.processRecordFile(buf)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"file":  hdr.Name,
+				"error": err,
+			}).Warning("error processing file from tarball")
+			fs.Rejected = countRecords(buf)
+			fs.Error = err.Error()
+			for i := 0; i < fs.Rejected; i++ {
+				g.Events <- GOT_RECORD
+				g.Events <- RECORD_ERROR
+			}
+			continue
+		}
+		// unrecognized records are passed along so that sendRecords
+		// can account for them, but they are never accepted.
+		for _, x := range bun.OtherRecords {
+			chunk.other = append(chunk.other, x)
+			chunk.otherFiles = append(chunk.otherFiles, fs)
+		}
+		for rec := range bun.Records() {
+			chunk.recs = append(chunk.recs, rec)
+			chunk.recFiles = append(chunk.recFiles, fs)
+			if len(chunk.recs) >= g.Config.TarballChunkSize {
+				g.sendTarballChunk(chunk)
+				chunk = &tarballChunk{}
+			}
+		}
+	}
+	// the last chunk may hold only unrecognized records from trailing
+	// files; it is still sent so that sendRecords accounts for them.
+	if len(chunk.recs) > 0 || len(chunk.other) > 0 {
+		g.sendTarballChunk(chunk)
+	}
+	for _, fs := range summary.Files {
+		summary.Accepted += fs.Accepted
+		summary.Rejected += fs.Rejected
+		summary.Dropped += fs.Dropped
+	}
+	return &summary, nil
+}
+
+// sendTarballChunk publishes the records in chunk and updates
+// the summaries of the files they came from.
+func (g *GraccCollector) sendTarballChunk(chunk *tarballChunk) {
+	outcomes, err := g.sendRecords(chunk.recs, chunk.other)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"records": len(chunk.recs),
+			"error":   err,
+		}).Error("error sending tarball chunk")
+	}
+	files := append(chunk.recFiles, chunk.otherFiles...)
+	for i, o := range outcomes {
+		fs := files[i]
+		switch o {
+		case outcomePublished:
+			fs.Accepted++
+		case outcomeDenied, outcomeDropped:
+			fs.Dropped++
+		default:
+			fs.Rejected++
+			if err != nil && fs.Error == "" {
+				fs.Error = err.Error()
+			}
+		}
+	}
+}
+
+// countRecords returns the number of records in a record file that could not
+// be parsed, as far as it can be read: the records of a replication bundle,
+// the elements of a record bundle (RecordEnvelope), or else 1.
+func countRecords(buf []byte) int {
+	buf = bytes.TrimSpace(buf)
+	n := 0
+	if bytes.HasPrefix(buf, []byte("replication|")) {
+		bs := bufio.NewScanner(bytes.NewReader(buf))
+		bs.Buffer(make([]byte, 4096), len(buf)+1)
+		bs.Split(ScanBundle)
+		for bs.Scan() {
+			if bs.Text() == "replication" {
+				n++
+			}
+		}
+	} else {
+		d := xml.NewDecoder(bytes.NewReader(buf))
+		depth := 0
+	TokenLoop:
+		for {
+			tok, err := d.RawToken()
+			if err != nil {
+				break
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				if depth == 0 && t.Name.Local != "RecordEnvelope" {
+					break TokenLoop
+				}
+				if depth == 1 {
+					n++
+				}
+				depth++
+			case xml.EndElement:
+				depth--
+			}
+		}
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
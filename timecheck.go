@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/opensciencegrid/gracc-collector/gracc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TimeCheckConfig configures the checks on record times relative to when the
+// record is received.
+//
+// Records with any of Fields more than MaxFuture ahead of or MaxPast behind
+// the receive time fail, and are handled according to Action, as for
+// validation rules (see ValidationRule). An empty MaxFuture or MaxPast is no
+// limit.
+//
+// With Skew, the clock skew of each probe is estimated from its records.
+// Records can only be delayed in transit, which makes the estimate smaller, so
+// the skew is the largest of the last SkewSamples estimates. If at least half
+// of them are within SkewTolerance of it, and the probe clock is at least
+// SkewMin ahead, the times in the records of the probe are corrected by it. A
+// probe clock that is behind is not corrected, since that can't be told apart
+// from records that were delayed (e.g. a backlog being sent).
+type TimeCheckConfig struct {
+	Fields        string `env:"FIELDS"`
+	MaxFuture     string `env:"MAXFUTURE"`
+	MaxPast       string `env:"MAXPAST"`
+	Action        string `env:"ACTION"`
+	Skew          bool   `env:"SKEW"`
+	SkewSamples   int    `env:"SKEWSAMPLES"`
+	SkewTolerance string `env:"SKEWTOLERANCE"`
+	SkewMin       string `env:"SKEWMIN"`
+	fields        []string
+	maxFuture     time.Duration
+	maxPast       time.Duration
+	skewTolerance time.Duration
+	skewMin       time.Duration
+}
+
+// clockFields are the times in records that are set by the probe clock, and
+// are corrected for skew.
+var clockFields = []string{"StartTime", "EndTime", "CreateTime", "Timestamp"}
+
+func (c *TimeCheckConfig) Validate() error {
+	if c.Fields == "" {
+		c.Fields = "EndTime,Timestamp,CreateTime"
+	}
+	c.fields = splitPatterns(c.Fields)
+	var err error
+	for _, d := range []struct {
+		name string
+		s    *string
+		def  string
+		d    *time.Duration
+	}{
+		{"MaxFuture", &c.MaxFuture, "", &c.maxFuture},
+		{"MaxPast", &c.MaxPast, "", &c.maxPast},
+		{"SkewTolerance", &c.SkewTolerance, "1m", &c.skewTolerance},
+		{"SkewMin", &c.SkewMin, "5m", &c.skewMin},
+	} {
+		if *d.s == "" {
+			*d.s = d.def
+		}
+		if *d.s == "" {
+			*d.d = 0
+		} else if *d.d, err = time.ParseDuration(*d.s); err != nil {
+			return fmt.Errorf("error parsing TimeCheck %s: %s", d.name, err)
+		}
+	}
+	switch c.Action {
+	case "":
+		c.Action = ValidationTag
+	case ValidationTag, ValidationReject, ValidationRejectBundle:
+	default:
+		return fmt.Errorf("unknown TimeCheck Action value %q", c.Action)
+	}
+	if c.SkewSamples <= 0 {
+		c.SkewSamples = 10
+	}
+	return nil
+}
+
+// enabled returns true if any checks or corrections are configured.
+func (c *TimeCheckConfig) enabled() bool {
+	return c.maxFuture > 0 || c.maxPast > 0 || c.Skew
+}
+
+// timeChecker is the stage that checks, and corrects, record times.
+type timeChecker struct {
+	conf     TimeCheckConfig
+	now      func() time.Time
+	m        sync.Mutex
+	probes   map[string]*probeSkew
+	failures map[string]uint64 // by field
+
+	SkewDesc    *prometheus.Desc
+	FailureDesc *prometheus.Desc
+}
+
+// probeSkew holds the recent clock skew estimates of a probe, in seconds.
+type probeSkew struct {
+	samples []float64
+	next    int
+}
+
+func newTimeChecker(conf TimeCheckConfig) *timeChecker {
+	return &timeChecker{
+		conf:     conf,
+		now:      time.Now,
+		probes:   make(map[string]*probeSkew),
+		failures: make(map[string]uint64),
+		SkewDesc: prometheus.NewDesc(
+			"gracc_probe_clock_skew_seconds",
+			"Estimated clock skew of each probe (largest estimate from recent records).",
+			[]string{"probe"},
+			nil,
+		),
+		FailureDesc: prometheus.NewDesc(
+			"gracc_time_check_failures_total",
+			"Number of records with times outside the acceptance window, by field.",
+			[]string{"field"},
+			nil,
+		),
+	}
+}
+
+// add adds an estimate, keeping the last n.
+func (p *probeSkew) add(s float64, n int) {
+	if len(p.samples) < n {
+		p.samples = append(p.samples, s)
+		return
+	}
+	p.samples[p.next] = s
+	p.next = (p.next + 1) % n
+}
+
+// max returns the largest estimate.
+func (p *probeSkew) max() float64 {
+	m := p.samples[0]
+	for _, s := range p.samples[1:] {
+		m = math.Max(m, s)
+	}
+	return m
+}
+
+// correction returns the correction to make to the times of the probe, in
+// seconds, or 0 if the skew is not consistent, small or behind.
+func (p *probeSkew) correction(c *TimeCheckConfig) float64 {
+	if len(p.samples) < c.SkewSamples {
+		return 0
+	}
+	m := p.max()
+	if m < c.skewMin.Seconds() {
+		return 0
+	}
+	var n int
+	for _, s := range p.samples {
+		if m-s <= c.skewTolerance.Seconds() {
+			n++
+		}
+	}
+	if 2*n < len(p.samples) {
+		return 0
+	}
+	return math.Floor(m + 0.5)
+}
+
+// timeValue returns the time in raw record field k.
+func timeValue(r map[string]interface{}, k string) (time.Time, bool) {
+	s, ok := r[k].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	return t, err == nil
+}
+
+// skewSample returns the estimated clock skew of the probe that sent r: the
+// time the record was created (CreateTime, or Timestamp) by the probe clock
+// less the time the first collector received it (OriginServerDate) or, for
+// records received directly, now.
+func skewSample(r map[string]interface{}, now time.Time) (float64, bool) {
+	t, ok := timeValue(r, "CreateTime")
+	if !ok {
+		if t, ok = timeValue(r, "Timestamp"); !ok {
+			return 0, false
+		}
+	}
+	if st, ok := timeValue(r, "OriginServerDate"); ok {
+		now = st
+	}
+	return t.Sub(now).Seconds(), true
+}
+
+func (c *timeChecker) process(rec gracc.Record, r map[string]interface{}) (bool, error) {
+	now := c.now()
+	probe, _ := r["ProbeName"].(string)
+
+	if c.conf.Skew && probe != "" {
+		var corr float64
+		c.m.Lock()
+		p := c.probes[probe]
+		if p == nil {
+			p = &probeSkew{}
+			c.probes[probe] = p
+		}
+		if s, ok := skewSample(r, now); ok {
+			p.add(s, c.conf.SkewSamples)
+		}
+		corr = p.correction(&c.conf)
+		c.m.Unlock()
+		if corr != 0 {
+			var fields []string
+			for _, k := range clockFields {
+				if t, ok := timeValue(r, k); ok {
+					r[k] = t.Add(-time.Duration(corr) * time.Second).Format(time.RFC3339)
+					fields = append(fields, k)
+				}
+			}
+			if len(fields) > 0 {
+				r["_clockSkew"] = corr
+				r["_clockSkewCorrected"] = fields
+			}
+		}
+	}
+
+	for _, k := range c.conf.fields {
+		t, ok := timeValue(r, k)
+		if !ok {
+			continue
+		}
+		var msg string
+		if c.conf.maxFuture > 0 && t.After(now.Add(c.conf.maxFuture)) {
+			msg = fmt.Sprintf("%s %s is more than %s in the future", k, r[k], c.conf.MaxFuture)
+		} else if c.conf.maxPast > 0 && t.Before(now.Add(-c.conf.maxPast)) {
+			msg = fmt.Sprintf("%s %s is more than %s in the past", k, r[k], c.conf.MaxPast)
+		} else {
+			continue
+		}
+		c.m.Lock()
+		c.failures[k]++
+		c.m.Unlock()
+		l := log.WithFields(log.Fields{
+			"type":   rec.Type(),
+			"id":     rec.Id(),
+			"probe":  probe,
+			"action": c.conf.Action,
+			"error":  msg,
+		})
+		switch c.conf.Action {
+		case ValidationTag:
+			l.Debug("record failed time check")
+			tagRecord(r, "time-window: "+msg)
+		case ValidationReject:
+			l.Warning("record failed time check; dropping")
+			return false, nil
+		case ValidationRejectBundle:
+			l.Warning("record failed time check; rejecting bundle")
+			return false, NewRecordError(fmt.Sprintf("%s record %s failed time check: %s", rec.Type(), rec.Id(), msg))
+		}
+	}
+	return true, nil
+}
+
+func (c *timeChecker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.SkewDesc
+	ch <- c.FailureDesc
+}
+
+func (c *timeChecker) Collect(ch chan<- prometheus.Metric) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for probe, p := range c.probes {
+		if len(p.samples) == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.SkewDesc,
+			prometheus.GaugeValue,
+			p.max(),
+			probe,
+		)
+	}
+	for _, k := range c.conf.fields {
+		ch <- prometheus.MustNewConstMetric(
+			c.FailureDesc,
+			prometheus.CounterValue,
+			float64(c.failures[k]),
+			k,
+		)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/opensciencegrid/gracc-collector/gracc"
+)
+
+var testNow = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestTimeChecker(t *testing.T, conf TimeCheckConfig) *timeChecker {
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	c := newTimeChecker(conf)
+	c.now = func() time.Time { return testNow }
+	return c
+}
+
+func TestTimeCheck(t *testing.T) {
+	for _, tc := range []struct {
+		action  string
+		endTime string
+		keep    bool
+		err     bool
+		tagged  bool
+	}{
+		{ValidationReject, "2020-01-01T11:00:00Z", true, false, false},
+		{ValidationReject, "2030-01-01T11:00:00Z", false, false, false},
+		{ValidationReject, "1990-01-01T11:00:00Z", false, false, false},
+		{ValidationRejectBundle, "2030-01-01T11:00:00Z", false, true, false},
+		{ValidationTag, "2030-01-01T11:00:00Z", true, false, true},
+		{ValidationTag, "2019-06-01T11:00:00+02:00", true, false, false},
+	} {
+		c := newTestTimeChecker(t, TimeCheckConfig{MaxFuture: "1h", MaxPast: "8760h", Action: tc.action})
+		r := map[string]interface{}{"type": "JobUsageRecord", "EndTime": tc.endTime}
+		keep, err := c.process(&gracc.JobUsageRecord{}, r)
+		if (err != nil) != tc.err {
+			t.Errorf("%s %s: unexpected error %v", tc.action, tc.endTime, err)
+		} else if err == nil && keep != tc.keep {
+			t.Errorf("%s %s: expected keep %v", tc.action, tc.endTime, tc.keep)
+		}
+		if _, ok := r["_validation"]; ok != tc.tagged {
+			t.Errorf("%s %s: expected tagged %v, got %v", tc.action, tc.endTime, tc.tagged, r["_validation"])
+		}
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	c := newTestTimeChecker(t, TimeCheckConfig{Skew: true, SkewSamples: 3, MaxFuture: "1h"})
+	for i, tc := range []struct {
+		probe      string
+		createTime string
+		serverDate string
+		skew       interface{} // expected _clockSkew, nil if not corrected
+		endTime    string
+	}{
+		{"p", "2020-01-01T14:00:00Z", "", nil, "2020-01-01T13:30:00Z"},
+		{"p", "2020-01-01T14:00:10Z", "", nil, "2020-01-01T13:30:00Z"},
+		{"p", "2020-01-01T14:00:20Z", "", 7220.0, "2020-01-01T11:29:40Z"},
+		{"p", "2019-12-01T14:00:00Z", "2019-12-01T12:00:00Z", 7220.0, "2020-01-01T11:29:40Z"},
+		// delayed records don't change the skew
+		{"p", "2020-01-01T13:00:00Z", "", 7220.0, "2020-01-01T11:29:40Z"},
+		{"q", "2020-01-01T14:00:00Z", "", nil, "2020-01-01T13:30:00Z"},
+		{"q", "2020-01-01T12:00:00Z", "", nil, "2020-01-01T13:30:00Z"},
+		{"q", "2020-01-01T16:00:00Z", "", nil, "2020-01-01T13:30:00Z"},
+		// clocks that are behind are not corrected
+		{"r", "2020-01-01T10:00:00Z", "", nil, "2020-01-01T13:30:00Z"},
+		{"r", "2020-01-01T10:00:00Z", "", nil, "2020-01-01T13:30:00Z"},
+		{"r", "2020-01-01T10:00:00Z", "", nil, "2020-01-01T13:30:00Z"},
+	} {
+		r := map[string]interface{}{"type": "JobUsageRecord", "ProbeName": tc.probe,
+			"CreateTime": tc.createTime, "EndTime": "2020-01-01T13:30:00Z"}
+		if tc.serverDate != "" {
+			r["OriginServerDate"] = tc.serverDate
+		}
+		keep, err := c.process(&gracc.JobUsageRecord{}, r)
+		if !keep || err != nil {
+			t.Fatalf("%d: record rejected: %v", i, err)
+		}
+		if r["_clockSkew"] != tc.skew {
+			t.Errorf("%d: expected skew %v, got %v", i, tc.skew, r["_clockSkew"])
+		}
+		if r["EndTime"] != tc.endTime {
+			t.Errorf("%d: expected EndTime %v, got %v", i, tc.endTime, r["EndTime"])
+		}
+		if tc.skew != nil {
+			if f := r["_clockSkewCorrected"]; !reflect.DeepEqual(f, []string{"EndTime", "CreateTime"}) {
+				t.Errorf("%d: unexpected corrected fields %v", i, f)
+			}
+			if _, ok := r["_validation"]; ok {
+				t.Errorf("%d: corrected record failed time check: %v", i, r["_validation"])
+			}
+		} else if _, ok := r["_validation"]; !ok && tc.endTime > "2020-01-01T13" {
+			t.Errorf("%d: expected time check failure", i)
+		}
+	}
+	if m := c.probes["p"].max(); m != 7220 {
+		t.Errorf("expected skew 7220 for p, got %v", m)
+	}
+}
+
+func TestClockSkewBacklog(t *testing.T) {
+	c := newTestTimeChecker(t, TimeCheckConfig{Skew: true, SkewSamples: 10})
+	// a probe with a correct clock sends a spooled batch created 3h ago and a
+	// day of records created every 10m, then keeps up
+	for i := 0; i < 220; i++ {
+		created := testNow.Add(-3 * time.Hour)
+		if i >= 20 {
+			created = testNow.Add(time.Duration(i-164) * 10 * time.Minute)
+		}
+		if created.After(testNow) {
+			c.now = func() time.Time { return created }
+		}
+		r := map[string]interface{}{"type": "JobUsageRecord", "ProbeName": "p",
+			"CreateTime": created.Format(time.RFC3339)}
+		if keep, err := c.process(&gracc.JobUsageRecord{}, r); !keep || err != nil {
+			t.Fatalf("%d: record rejected: %v", i, err)
+		}
+		if s, ok := r["_clockSkew"]; ok {
+			t.Fatalf("%d: unexpected skew correction %v", i, s)
+		}
+	}
+	if m := c.probes["p"].max(); m != 0 {
+		t.Errorf("expected skew 0, got %v", m)
+	}
+}
+
+func TestTimeCheckConfig(t *testing.T) {
+	for _, c := range []TimeCheckConfig{
+		{MaxFuture: "1 day"},
+		{SkewMin: "x"},
+		{Action: "drop"},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v: expected error", c)
+		}
+	}
+	var c TimeCheckConfig
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.enabled() {
+		t.Error("default time checks enabled")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensciencegrid/gracc-collector/gracc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TopologyConfig configures the mapping of probes to their canonical site and
+// resource names, from the JSON topology File. The file is checked for changes
+// every Interval.
+type TopologyConfig struct {
+	File             string        `env:"FILE"`
+	Interval         string        `env:"INTERVAL"`
+	IntervalDuration time.Duration `env:"-"`
+}
+
+func (c *TopologyConfig) Validate() error {
+	return parseInterval("Topology", &c.Interval, &c.IntervalDuration)
+}
+
+// topologyEntry maps records whose ProbeName matches Probe, and whose probe
+// host (the part of the ProbeName after the probe type, e.g.
+// "condor:<host>") matches Host, to the canonical names. Probe and Host
+// are shell patterns (see path.Match), and empty patterns match any probe.
+type topologyEntry struct {
+	Probe         string
+	Host          string
+	Site          string
+	Facility      string
+	ResourceGroup string
+	Resource      string
+}
+
+// fields returns the raw record fields set by the entry.
+func (e *topologyEntry) fields() map[string]string {
+	var f = make(map[string]string, 4)
+	for k, v := range map[string]string{
+		"Site":          e.Site,
+		"Facility":      e.Facility,
+		"ResourceGroup": e.ResourceGroup,
+		"Resource":      e.Resource,
+	} {
+		if v != "" {
+			f[k] = v
+		}
+	}
+	return f
+}
+
+func (e *topologyEntry) matches(probe string) bool {
+	if ok, _ := path.Match(e.Probe, probe); e.Probe != "" && !ok {
+		return false
+	}
+	if e.Host != "" {
+		host := probe
+		if i := strings.Index(probe, ":"); i >= 0 {
+			host = probe[i+1:]
+		}
+		if ok, _ := path.Match(e.Host, host); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// loadTopology reads the topology entries from file.
+func loadTopology(file string) ([]topologyEntry, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entries []topologyEntry
+	if err := json.Unmarshal(buf, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing topology file %s: %s", file, err)
+	}
+	for i, e := range entries {
+		for _, p := range []string{e.Probe, e.Host} {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("topology file %s entry %d: bad pattern %q", file, i+1, p)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// topology is the stage that adds the canonical site and resource names to
+// records, by their ProbeName.
+type topology struct {
+	watchedFile
+	entries  []topologyEntry
+	em       sync.RWMutex
+	um       sync.Mutex
+	unmapped map[string]uint64 // by probe
+
+	UnmappedDesc *prometheus.Desc
+}
+
+// newTopology loads the topology file, and starts watching it for changes.
+func newTopology(conf TopologyConfig) (*topology, error) {
+	var t = &topology{
+		unmapped: make(map[string]uint64),
+		UnmappedDesc: prometheus.NewDesc(
+			"gracc_topology_unmapped_total",
+			"Number of records whose probe is not in the topology file, by probe.",
+			[]string{"probe"},
+			nil,
+		),
+	}
+	t.watchedFile = watchedFile{file: conf.File, load: t.load}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	t.watch(conf.IntervalDuration)
+	return t, nil
+}
+
+func (t *topology) load(file string) error {
+	entries, err := loadTopology(file)
+	if err != nil {
+		return err
+	}
+	t.em.Lock()
+	t.entries = entries
+	t.em.Unlock()
+	return nil
+}
+
+func (t *topology) process(rec gracc.Record, r map[string]interface{}) (bool, error) {
+	probe, _ := r["ProbeName"].(string)
+	if probe == "" {
+		return true, nil
+	}
+	t.em.RLock()
+	defer t.em.RUnlock()
+	for i := range t.entries {
+		if t.entries[i].matches(probe) {
+			for k, v := range t.entries[i].fields() {
+				r[k] = v
+			}
+			return true, nil
+		}
+	}
+	t.um.Lock()
+	t.unmapped[probe]++
+	t.um.Unlock()
+	return true, nil
+}
+
+func (t *topology) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.UnmappedDesc
+}
+
+func (t *topology) Collect(ch chan<- prometheus.Metric) {
+	t.um.Lock()
+	defer t.um.Unlock()
+	for probe, n := range t.unmapped {
+		ch <- prometheus.MustNewConstMetric(
+			t.UnmappedDesc,
+			prometheus.CounterValue,
+			float64(n),
+			probe,
+		)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opensciencegrid/gracc-collector/gracc"
+)
+
+func TestTopology(t *testing.T) {
+	dir := writeTemp(t, "gracc-topology", map[string]string{"topology.json": `[
+		{"probe": "slurm:ce01.brazos.tamu.edu", "site": "TAMU", "facility": "Texas A&M", "resourceGroup": "TAMU_BRAZOS", "resource": "TAMU_BRAZOS_CE"},
+		{"host": "*.ucsd.edu", "site": "UCSD", "facility": "UC San Diego"}
+	]`})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "topology.json")
+	conf := TopologyConfig{File: file}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	topo, err := newTopology(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer topo.close()
+
+	for _, tc := range []struct {
+		probe  string
+		expect map[string]interface{} // expected values; nil means absent
+	}{
+		{"slurm:ce01.brazos.tamu.edu", map[string]interface{}{"Site": "TAMU", "Facility": "Texas A&M",
+			"ResourceGroup": "TAMU_BRAZOS", "Resource": "TAMU_BRAZOS_CE"}},
+		{"condor:osg-gw-7.t2.ucsd.edu", map[string]interface{}{"Site": "UCSD", "Facility": "UC San Diego",
+			"ResourceGroup": nil, "Resource": nil}},
+		{"awsvm:kretzke-dev", map[string]interface{}{"Site": nil}},
+		{"awsvm:kretzke-dev", map[string]interface{}{"Site": nil}},
+		{"", map[string]interface{}{"Site": nil}},
+	} {
+		r := map[string]interface{}{"type": "JobUsageRecord", "ProbeName": tc.probe}
+		if keep, err := topo.process(&gracc.JobUsageRecord{}, r); !keep || err != nil {
+			t.Fatalf("%s: record rejected: %v", tc.probe, err)
+		}
+		checkFields(t, tc.probe, r, tc.expect)
+	}
+	if n := topo.unmapped["awsvm:kretzke-dev"]; n != 2 || len(topo.unmapped) != 1 {
+		t.Errorf("unexpected unmapped counts %v", topo.unmapped)
+	}
+
+	// an invalid file is not loaded
+	if err := ioutil.WriteFile(file, []byte(`[{"probe": "["}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := topo.reload(); err == nil {
+		t.Error("expected error reloading invalid file")
+	}
+	if len(topo.entries) != 2 {
+		t.Errorf("entries changed by invalid file: %v", topo.entries)
+	}
+
+	// changes are reloaded
+	if err := ioutil.WriteFile(file, []byte(`[{"probe": "awsvm:*", "site": "AWS"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, time.Now(), time.Now().Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := topo.reload(); err != nil {
+		t.Fatal(err)
+	}
+	r := map[string]interface{}{"type": "StorageElementRecord", "ProbeName": "awsvm:kretzke-dev"}
+	topo.process(&gracc.StorageElementRecord{}, r)
+	if r["Site"] != "AWS" {
+		t.Errorf("topology not reloaded: %v", r)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/opensciencegrid/gracc-collector/gracc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ValidationConfig configures the checks on records before they are sent.
+// Records are checked against each rule in Rules, in order. With DryRun
+// the results are only logged and counted, and records are not changed or
+// rejected.
+type ValidationConfig struct {
+	DryRun bool             `env:"DRYRUN"`
+	Rules  []ValidationRule `env:"-"`
+}
+
+// ValidationRule checks a field of the raw record.
+//
+// Types is a comma-separated list of the record types the rule applies to,
+// or all types if empty. If Required is set the field must be present and
+// not empty; otherwise the other checks are skipped if it is absent. Min and
+// Max are the allowed range of the field. Op compares the field with Value, a
+// product of fields and numbers separated by "*" (e.g. "WallDuration *
+// Processors * 2"); the comparison is skipped if any of them are absent, which
+// is logged at debug level and counted.
+// Times are compared as seconds since the epoch.
+//
+// Durations are checked as given in the record, since invalid durations are
+// zero in the raw record: a duration that is absent or empty is absent, and
+// one that can't be parsed (e.g. a negative duration) fails the rule.
+//
+// Action is ValidationTag (default) to list the failure in the "_validation"
+// field of the record, ValidationReject to drop the record, or
+// ValidationRejectBundle to reject the bundle the record is in.
+type ValidationRule struct {
+	Name     string
+	Types    string
+	Field    string
+	Required bool
+	Min      *float64
+	Max      *float64
+	Op       string
+	Value    string
+	Action   string
+	types    map[string]bool
+	value    []string
+}
+
+const (
+	ValidationTag          = "tag"
+	ValidationReject       = "reject"
+	ValidationRejectBundle = "reject-bundle"
+)
+
+func (c *ValidationConfig) Validate() error {
+	var names = make(map[string]bool)
+	for i := range c.Rules {
+		v := &c.Rules[i]
+		if v.Name == "" {
+			return fmt.Errorf("Validation rule %d has no Name", i+1)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("duplicate Validation rule %q", v.Name)
+		}
+		names[v.Name] = true
+		if v.Field == "" {
+			return fmt.Errorf("Validation rule %q has no Field", v.Name)
+		}
+		v.types = splitTypes(v.Types)
+		switch v.Op {
+		case "":
+			if v.Value != "" {
+				return fmt.Errorf("Validation rule %q has Value but no Op", v.Name)
+			}
+		case "<", "<=", ">", ">=", "==", "!=":
+			v.value = v.value[:0]
+			for _, t := range strings.Split(v.Value, "*") {
+				if t = strings.TrimSpace(t); t == "" {
+					return fmt.Errorf("bad Validation rule %q Value %q", v.Name, v.Value)
+				}
+				v.value = append(v.value, t)
+			}
+		default:
+			return fmt.Errorf("unknown Validation rule %q Op %q", v.Name, v.Op)
+		}
+		switch v.Action {
+		case "":
+			v.Action = ValidationTag
+		case ValidationTag, ValidationReject, ValidationRejectBundle:
+		default:
+			return fmt.Errorf("unknown Validation rule %q Action %q", v.Name, v.Action)
+		}
+	}
+	return nil
+}
+
+// check checks raw record r, with durations durs as given by
+// gracc.DurationValues, returning a description of the failure, or "" if r
+// passes or the rule does not apply. If the comparison with Value is skipped
+// because one of its fields is absent, skipped is the name of the field.
+func (v *ValidationRule) check(r map[string]interface{}, durs map[string][]string) (msg, skipped string) {
+	if v.types != nil && !v.types[fmt.Sprint(r["type"])] {
+		return "", ""
+	}
+	x, ok := r[v.Field]
+	if d, isDuration := durs[v.Field]; isDuration {
+		if len(d) == 0 {
+			ok = false
+		}
+		if s := invalidDuration(d); s != "" {
+			return fmt.Sprintf("%s %q is not a valid duration", v.Field, s), ""
+		}
+	}
+	if !ok || x == nil || x == "" {
+		if v.Required {
+			return fmt.Sprintf("missing %s", v.Field), ""
+		}
+		return "", ""
+	}
+	if v.Min == nil && v.Max == nil && v.Op == "" {
+		return "", ""
+	}
+	n, ok := numberValue(x)
+	if !ok {
+		return fmt.Sprintf("%s %v is not a number", v.Field, x), ""
+	}
+	if v.Min != nil && n < *v.Min {
+		return fmt.Sprintf("%s %v is less than %v", v.Field, x, *v.Min), ""
+	}
+	if v.Max != nil && n > *v.Max {
+		return fmt.Sprintf("%s %v is greater than %v", v.Field, x, *v.Max), ""
+	}
+	if v.Op == "" {
+		return "", ""
+	}
+	var m = 1.0
+	for _, t := range v.value {
+		if d, isDuration := durs[t]; isDuration && (len(d) == 0 || invalidDuration(d) != "") {
+			return "", t
+		}
+		if f, err := strconv.ParseFloat(t, 64); err == nil {
+			m *= f
+		} else if f, ok := numberValue(r[t]); ok {
+			m *= f
+		} else {
+			return "", t
+		}
+	}
+	var pass bool
+	switch v.Op {
+	case "<":
+		pass = n < m
+	case "<=":
+		pass = n <= m
+	case ">":
+		pass = n > m
+	case ">=":
+		pass = n >= m
+	case "==":
+		pass = n == m
+	case "!=":
+		pass = n != m
+	}
+	if !pass {
+		return fmt.Sprintf("%s %v is not %s %s (%v)", v.Field, x, v.Op, v.Value, m), ""
+	}
+	return "", ""
+}
+
+// invalidDuration returns the first of durations d that can't be parsed,
+// or "" if they all can.
+func invalidDuration(d []string) string {
+	for _, s := range d {
+		if _, err := gracc.ParseDuration(s); err != nil {
+			return s
+		}
+	}
+	return ""
+}
+
+// numberValue returns the value of raw record field x as a number. Times are
+// seconds since the epoch.
+func numberValue(x interface{}) (float64, bool) {
+	if f, ok := gracc.Number(x); ok {
+		return f, true
+	}
+	if s, ok := x.(string); ok {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return float64(t.UnixNano()) / 1e9, true
+		}
+	}
+	return 0, false
+}
+
+// validator is the stage that checks records against the validation rules.
+type validator struct {
+	rules    []ValidationRule
+	dryRun   bool
+	m        sync.Mutex
+	failures map[string]uint64 // by rule name
+	skipped  map[string]uint64 // by rule name
+
+	FailureDesc *prometheus.Desc
+	SkippedDesc *prometheus.Desc
+}
+
+func newValidator(conf ValidationConfig) *validator {
+	return &validator{
+		rules:    conf.Rules,
+		dryRun:   conf.DryRun,
+		failures: make(map[string]uint64),
+		skipped:  make(map[string]uint64),
+		FailureDesc: prometheus.NewDesc(
+			"gracc_validation_failures_total",
+			"Number of records failing validation, by rule.",
+			[]string{"rule", "action"},
+			prometheus.Labels{"dryrun": strconv.FormatBool(conf.DryRun)},
+		),
+		SkippedDesc: prometheus.NewDesc(
+			"gracc_validation_skipped_total",
+			"Number of records whose validation comparison was skipped because a field in Value was absent, by rule.",
+			[]string{"rule"},
+			nil,
+		),
+	}
+}
+
+func (v *validator) process(rec gracc.Record, r map[string]interface{}) (bool, error) {
+	durs := gracc.DurationValues(rec)
+	for i := range v.rules {
+		rule := &v.rules[i]
+		msg, skipped := rule.check(r, durs)
+		if skipped != "" {
+			v.m.Lock()
+			v.skipped[rule.Name]++
+			v.m.Unlock()
+			log.WithFields(log.Fields{
+				"type":    rec.Type(),
+				"id":      rec.Id(),
+				"rule":    rule.Name,
+				"operand": skipped,
+			}).Debug("validation comparison skipped; operand absent")
+		}
+		if msg == "" {
+			continue
+		}
+		v.m.Lock()
+		v.failures[rule.Name]++
+		v.m.Unlock()
+		l := log.WithFields(log.Fields{
+			"type":   rec.Type(),
+			"id":     rec.Id(),
+			"rule":   rule.Name,
+			"action": rule.Action,
+			"error":  msg,
+		})
+		if v.dryRun {
+			l.Info("record failed validation (dry run)")
+			continue
+		}
+		switch rule.Action {
+		case ValidationTag:
+			l.Debug("record failed validation")
+			tagRecord(r, rule.Name+": "+msg)
+		case ValidationReject:
+			l.Warning("record failed validation; dropping")
+			return false, nil
+		case ValidationRejectBundle:
+			l.Warning("record failed validation; rejecting bundle")
+			return false, NewRecordError(fmt.Sprintf("%s record %s failed validation rule %s: %s", rec.Type(), rec.Id(), rule.Name, msg))
+		}
+	}
+	return true, nil
+}
+
+func (v *validator) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.FailureDesc
+	ch <- v.SkippedDesc
+}
+
+func (v *validator) Collect(ch chan<- prometheus.Metric) {
+	v.m.Lock()
+	defer v.m.Unlock()
+	for i := range v.rules {
+		ch <- prometheus.MustNewConstMetric(
+			v.FailureDesc,
+			prometheus.CounterValue,
+			float64(v.failures[v.rules[i].Name]),
+			v.rules[i].Name,
+			v.rules[i].Action,
+		)
+		if v.rules[i].Op != "" {
+			ch <- prometheus.MustNewConstMetric(
+				v.SkippedDesc,
+				prometheus.CounterValue,
+				float64(v.skipped[v.rules[i].Name]),
+				v.rules[i].Name,
+			)
+		}
+	}
+}
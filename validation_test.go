@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/opensciencegrid/gracc-collector/gracc"
+)
+
+func testValidationConfig(t *testing.T, dryRun bool) ValidationConfig {
+	zero := 0.0
+	conf := ValidationConfig{
+		DryRun: dryRun,
+		Rules: []ValidationRule{
+			{Name: "end-after-start", Types: "JobUsageRecord", Field: "EndTime", Op: ">=", Value: "StartTime", Action: ValidationRejectBundle},
+			{Name: "wall", Field: "WallDuration", Required: true, Min: &zero},
+			{Name: "cpu", Types: "JobUsageRecord", Field: "CpuDuration", Op: "<=", Value: "WallDuration * Processors * 2"},
+			{Name: "vo", Types: "JobUsageRecord", Field: "VOName", Required: true, Action: ValidationReject},
+		},
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+func TestValidation(t *testing.T) {
+	for _, tc := range []struct {
+		r     map[string]interface{}
+		keep  bool
+		err   bool
+		tags  []string
+		dtags []string // with dry run
+	}{
+		{
+			r: map[string]interface{}{"type": "JobUsageRecord", "VOName": "cms",
+				"StartTime": "2016-05-02T16:00:25Z", "EndTime": "2016-05-02T16:00:46Z",
+				"WallDuration": json.Number("21"), "CpuDuration": 0.81, "Processors": "1"},
+			keep: true,
+		},
+		{
+			r: map[string]interface{}{"type": "JobUsageRecord", "VOName": "cms",
+				"StartTime": "2016-05-02T16:00:25Z", "EndTime": "2016-05-02T15:00:46Z",
+				"WallDuration": json.Number("21")},
+			err: true,
+		},
+		{
+			r: map[string]interface{}{"type": "JobUsageRecord", "VOName": "cms",
+				"WallDuration": -5.0, "CpuDuration": 100.0, "Processors": "4"},
+			keep: true,
+			tags: []string{"wall: WallDuration -5 is less than 0", "cpu: CpuDuration 100 is not <= WallDuration * Processors * 2 (-40)"},
+		},
+		{
+			// no Processors; cpu check is skipped
+			r: map[string]interface{}{"type": "JobUsageRecord", "VOName": "cms",
+				"WallDuration": 10.0, "CpuDuration": 100.0},
+			keep: true,
+		},
+		{
+			r:    map[string]interface{}{"type": "JobUsageRecord", "VOName": "", "WallDuration": 10.0},
+			keep: false,
+		},
+		{
+			r:    map[string]interface{}{"type": "StorageElementRecord"},
+			keep: true,
+			tags: []string{"wall: missing WallDuration"},
+		},
+	} {
+		for _, dryRun := range []bool{false, true} {
+			v := newValidator(testValidationConfig(t, dryRun))
+			r := make(map[string]interface{})
+			for k, x := range tc.r {
+				r[k] = x
+			}
+			// the record has no durations, so the raw fields are checked as given
+			keep, err := v.process(&gracc.GenericRecord{}, r)
+			if dryRun {
+				if !keep || err != nil || r["_validation"] != nil {
+					t.Errorf("%v: dry run changed record: %v %v %v", tc.r, keep, err, r["_validation"])
+				}
+				continue
+			}
+			if (err != nil) != tc.err {
+				t.Errorf("%v: unexpected error %v", tc.r, err)
+			} else if _, ok := err.(RecordError); err != nil && !ok {
+				t.Errorf("%v: expected RecordError, got %T", tc.r, err)
+			}
+			if err == nil && keep != tc.keep {
+				t.Errorf("%v: expected keep %v", tc.r, tc.keep)
+			}
+			if tags, _ := r["_validation"].([]string); !reflect.DeepEqual(tags, tc.tags) {
+				t.Errorf("%v: expected tags %q, got %q", tc.r, tc.tags, tags)
+			}
+		}
+	}
+}
+
+func TestValidationDurations(t *testing.T) {
+	buf, err := ioutil.ReadFile("gracc/test_data/JobUsageRecord01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := ValidationConfig{Rules: []ValidationRule{
+		{Name: "wall", Types: "JobUsageRecord", Field: "WallDuration", Required: true},
+		{Name: "cpu", Types: "JobUsageRecord", Field: "CpuDuration", Op: "<=", Value: "WallDuration"},
+	}}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	v := newValidator(conf)
+	for _, tc := range []struct {
+		old, new string
+		tags     []string
+	}{
+		{"", "", nil},
+		{"<WallDuration >PT1H</WallDuration>", "<WallDuration >-PT1H</WallDuration>",
+			[]string{`wall: WallDuration "-PT1H" is not a valid duration`}},
+		{"<WallDuration >PT1H</WallDuration>", "<WallDuration>1 hour</WallDuration>",
+			[]string{`wall: WallDuration "1 hour" is not a valid duration`}},
+		// no WallDuration, so cpu check is skipped
+		{"<WallDuration >PT1H</WallDuration>", "", []string{"wall: missing WallDuration"}},
+		{"<WallDuration >PT1H</WallDuration>", "<WallDuration >PT1S</WallDuration>",
+			[]string{"cpu: CpuDuration 65.32 is not <= WallDuration (1)"}},
+		{`<CpuDuration urwg:usageType="system" >PT0S</CpuDuration>`, `<CpuDuration urwg:usageType="system" >PT-1S</CpuDuration>`,
+			[]string{`cpu: CpuDuration "PT-1S" is not a valid duration`}},
+	} {
+		rec, err := gracc.ParseRecordXML(bytes.Replace(buf, []byte(tc.old), []byte(tc.new), 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := rawRecord(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keep, err := v.process(rec, r); !keep || err != nil {
+			t.Fatalf("%s: record rejected: %v", tc.new, err)
+		}
+		if tags, _ := r["_validation"].([]string); !reflect.DeepEqual(tags, tc.tags) {
+			t.Errorf("%s: expected tags %q, got %q", tc.new, tc.tags, tags)
+		}
+	}
+}
+
+func TestValidationSkipped(t *testing.T) {
+	conf := ValidationConfig{Rules: []ValidationRule{
+		{Name: "typo", Types: "JobUsageRecord", Field: "WallDuration", Op: "<=", Value: "Proccessors * 1000"},
+		{Name: "ok", Types: "JobUsageRecord", Field: "WallDuration", Op: ">=", Value: "0"},
+	}}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	v := newValidator(conf)
+	rec, r := loadRecord(t, "gracc/test_data/JobUsageRecord01.xml")
+	if keep, err := v.process(rec, r); !keep || err != nil {
+		t.Fatalf("record rejected: %v", err)
+	}
+	if tags, ok := r["_validation"]; ok {
+		t.Errorf("expected no tags, got %q", tags)
+	}
+	if n := v.skipped["typo"]; n != 1 {
+		t.Errorf("expected 1 skipped comparison for typo, got %d", n)
+	}
+	if n := v.skipped["ok"]; n != 0 {
+		t.Errorf("expected 0 skipped comparisons for ok, got %d", n)
+	}
+}
+
+func TestValidationConfig(t *testing.T) {
+	for _, c := range []ValidationConfig{
+		{Rules: []ValidationRule{{Field: "VOName", Required: true}}},
+		{Rules: []ValidationRule{{Name: "vo", Required: true}}},
+		{Rules: []ValidationRule{{Name: "vo", Field: "VOName"}, {Name: "vo", Field: "ProbeName"}}},
+		{Rules: []ValidationRule{{Name: "x", Field: "EndTime", Op: "=>", Value: "StartTime"}}},
+		{Rules: []ValidationRule{{Name: "x", Field: "EndTime", Op: ">=", Value: "StartTime * "}}},
+		{Rules: []ValidationRule{{Name: "x", Field: "EndTime", Value: "StartTime"}}},
+		{Rules: []ValidationRule{{Name: "x", Field: "EndTime", Action: "drop"}}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v: expected error", c)
+		}
+	}
+}
+
+func TestCheckStageFormats(t *testing.T) {
+	for _, tc := range []struct {
+		amqp, kafka string
+		kafkaEnable bool
+		err         bool
+	}{
+		{"json", "protobuf", true, false},
+		{"protobuf", "raw", false, false},
+		{"raw", "json", false, true},
+		{"json", "ur-json", true, true},
+		{"xml", "json", true, true},
+	} {
+		conf := &CollectorConfig{
+			AMQP:  AMQPConfig{Enable: true, Format: tc.amqp},
+			Kafka: KafkaConfig{Enable: tc.kafkaEnable, Format: tc.kafka},
+		}
+		if err := checkStageFormats(conf); (err != nil) != tc.err {
+			t.Errorf("%+v: unexpected error %v", tc, err)
+		}
+	}
+}
+
+func TestProcessRecords(t *testing.T) {
+	buf, err := ioutil.ReadFile("gracc/test_data/JobUsageRecord01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := gracc.ParseRecordXML(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := ValidationConfig{Rules: []ValidationRule{
+		{Name: "short", Field: "WallDuration", Op: "<", Value: "60"},
+	}}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	g := &GraccCollector{stages: []stage{newValidator(conf)}}
+	recs, err := g.processRecords([]gracc.Record{rec}, []int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0] == nil || recs[0].Id() != rec.Id() {
+		t.Fatalf("unexpected records %v", recs)
+	}
+	j, err := recs[0].ToJSON("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r map[string]interface{}
+	if err := json.Unmarshal(j, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r["ProbeName"] != "awsvm:kretzke-dev" || r["WallDuration"] != 3600.0 {
+		t.Errorf("record changed: %s", j)
+	}
+	if v, _ := r["_validation"].([]interface{}); len(v) != 1 {
+		t.Errorf("expected validation failure: %s", j)
+	}
+}
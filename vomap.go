@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensciencegrid/gracc-collector/gracc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// VOMapConfig configures the mapping of the DN of records with no VO or
+// global username, from a grid-mapfile (GridMapFile), a VOMS-mapfile
+// (VOMSMapFile), and a JSON list of regular expression rules (RulesFile).
+// The files are checked for changes every Interval.
+type VOMapConfig struct {
+	GridMapFile      string        `env:"GRIDMAPFILE"`
+	VOMSMapFile      string        `env:"VOMSMAPFILE"`
+	RulesFile        string        `env:"RULESFILE"`
+	Interval         string        `env:"INTERVAL"`
+	IntervalDuration time.Duration `env:"-"`
+}
+
+func (c *VOMapConfig) Validate() error {
+	return parseInterval("VOMap", &c.Interval, &c.IntervalDuration)
+}
+
+func (c *VOMapConfig) enabled() bool {
+	return c.GridMapFile != "" || c.VOMSMapFile != "" || c.RulesFile != ""
+}
+
+// Sources of the values set by the VO mapping, in the "<field>_source" fields.
+const (
+	sourceGridMap = "grid-mapfile"
+	sourceVOMSMap = "voms-mapfile"
+	sourceRule    = "rule:"
+)
+
+// proxySuffix matches the proxy CNs at the end of a DN.
+var proxySuffix = regexp.MustCompile(`(/CN=(proxy|limited proxy))+$`)
+
+// normalizeDN returns dn without surrounding space or proxy CNs, so a proxy
+// DN maps as the DN of its certificate.
+func normalizeDN(dn string) string {
+	return proxySuffix.ReplaceAllString(strings.TrimSpace(dn), "")
+}
+
+// reportableVO returns the VO of FQAN vo (e.g. "cms" for
+// "/cms/Role=production/Capability=NULL"), or vo itself if it isn't one.
+func reportableVO(vo string) string {
+	if !strings.HasPrefix(vo, "/") {
+		return vo
+	}
+	vo = vo[1:]
+	if i := strings.Index(vo, "/"); i >= 0 {
+		vo = vo[:i]
+	}
+	return vo
+}
+
+// splitField splits the first field, which is quoted if it contains spaces,
+// from the rest of line.
+func splitField(line string) (string, string, error) {
+	if strings.HasPrefix(line, `"`) {
+		i := strings.Index(line[1:], `"`)
+		if i < 0 {
+			return "", "", fmt.Errorf("unterminated quote")
+		}
+		return line[1 : i+1], strings.TrimSpace(line[i+2:]), nil
+	}
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i:]), nil
+	}
+	return line, "", nil
+}
+
+// splitMapLine splits a mapfile line into the DN and the value that follows
+// it, either of which may be quoted.
+func splitMapLine(line string) (string, string, error) {
+	dn, rest, err := splitField(line)
+	if err != nil {
+		return "", "", err
+	}
+	value, _, err := splitField(rest)
+	if err != nil {
+		return "", "", err
+	}
+	if dn == "" || value == "" {
+		return "", "", fmt.Errorf("expected a DN and a value")
+	}
+	return normalizeDN(dn), value, nil
+}
+
+// loadMapFile reads a mapfile, with a DN and a value on each line, returning
+// the value for each DN, and ignoring blank lines and comments (starting with
+// "#"). Only the first value for each DN is kept.
+func loadMapFile(file string, value func(string) string) (map[string]string, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var m = make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dn, v, err := splitMapLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("mapfile %s line %d: %s", file, n, err)
+		}
+		if _, ok := m[dn]; !ok {
+			m[dn] = value(v)
+		}
+	}
+	return m, s.Err()
+}
+
+// loadGridMapFile reads a grid-mapfile, mapping each DN to a comma-separated
+// list of usernames, of which the first is kept.
+func loadGridMapFile(file string) (map[string]string, error) {
+	return loadMapFile(file, func(v string) string {
+		return strings.TrimSpace(strings.Split(v, ",")[0])
+	})
+}
+
+// loadVOMSMapFile reads a VOMS-mapfile, mapping each DN to a VO or FQAN.
+func loadVOMSMapFile(file string) (map[string]string, error) {
+	return loadMapFile(file, strings.TrimSpace)
+}
+
+// voMapRule maps DNs matching the regular expression DN to the VO,
+// ReportableVO and GlobalUsername, which may refer to its submatches (e.g.
+// "$1"). Only the values set are used, and ReportableVO is the VO of the FQAN
+// VO if it is not set.
+type voMapRule struct {
+	Name           string
+	DN             string
+	VO             string
+	ReportableVO   string
+	GlobalUsername string
+	dn             *regexp.Regexp
+}
+
+// loadVOMapRules reads and compiles the rules from file. Rules with no name
+// are named by their position in the file.
+func loadVOMapRules(file string) ([]voMapRule, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []voMapRule
+	if err := json.Unmarshal(buf, &rules); err != nil {
+		return nil, fmt.Errorf("error parsing VO mapping rules file %s: %s", file, err)
+	}
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			rule.Name = strconv.Itoa(i + 1)
+		}
+		if rule.dn, err = regexp.Compile(rule.DN); err != nil || rule.DN == "" {
+			return nil, fmt.Errorf("VO mapping rules file %s rule %s: bad DN regexp %q", file, rule.Name, rule.DN)
+		}
+		if rule.VO == "" && rule.ReportableVO == "" && rule.GlobalUsername == "" {
+			return nil, fmt.Errorf("VO mapping rules file %s rule %s: no values", file, rule.Name)
+		}
+	}
+	return rules, nil
+}
+
+// values returns the fields set by the rule for dn, if it matches.
+func (rule *voMapRule) values(dn string) map[string]string {
+	m := rule.dn.FindStringSubmatchIndex(dn)
+	if m == nil {
+		return nil
+	}
+	expand := func(tmpl string) string {
+		return string(rule.dn.ExpandString(nil, tmpl, dn, m))
+	}
+	var f = make(map[string]string, 3)
+	if rule.VO != "" {
+		f["VOName"] = expand(rule.VO)
+		f["ReportableVOName"] = reportableVO(f["VOName"])
+	}
+	if rule.ReportableVO != "" {
+		f["ReportableVOName"] = expand(rule.ReportableVO)
+	}
+	if rule.GlobalUsername != "" {
+		f["GlobalUsername"] = expand(rule.GlobalUsername)
+	}
+	return f
+}
+
+// voMap is the stage that fills in the VO and global username of records
+// from their DN.
+type voMap struct {
+	files    []*watchedFile
+	gridMap  map[string]string
+	vomsMap  map[string]string
+	rules    []voMapRule
+	mm       sync.RWMutex
+	um       sync.Mutex
+	unmapped map[string]uint64 // by probe
+
+	UnmappedDesc *prometheus.Desc
+}
+
+// newVOMap loads the mapfiles and rules, and starts watching them for changes.
+func newVOMap(conf VOMapConfig) (*voMap, error) {
+	var v = &voMap{
+		unmapped: make(map[string]uint64),
+		UnmappedDesc: prometheus.NewDesc(
+			"gracc_vomap_unmapped_total",
+			"Number of records with a DN but no VO, which could not be mapped, by probe.",
+			[]string{"probe"},
+			nil,
+		),
+	}
+	for _, f := range []struct {
+		file string
+		load func(string) error
+	}{
+		{conf.GridMapFile, v.loadGridMap},
+		{conf.VOMSMapFile, v.loadVOMSMap},
+		{conf.RulesFile, v.loadRules},
+	} {
+		if f.file == "" {
+			continue
+		}
+		w := &watchedFile{file: f.file, load: f.load}
+		if err := w.reload(); err != nil {
+			return nil, err
+		}
+		v.files = append(v.files, w)
+	}
+	for _, w := range v.files {
+		w.watch(conf.IntervalDuration)
+	}
+	return v, nil
+}
+
+// close stops watching the mapfiles and rules.
+func (v *voMap) close() {
+	for _, w := range v.files {
+		w.close()
+	}
+}
+
+func (v *voMap) loadGridMap(file string) error {
+	m, err := loadGridMapFile(file)
+	if err != nil {
+		return err
+	}
+	v.mm.Lock()
+	v.gridMap = m
+	v.mm.Unlock()
+	return nil
+}
+
+func (v *voMap) loadVOMSMap(file string) error {
+	m, err := loadVOMSMapFile(file)
+	if err != nil {
+		return err
+	}
+	v.mm.Lock()
+	v.vomsMap = m
+	v.mm.Unlock()
+	return nil
+}
+
+func (v *voMap) loadRules(file string) error {
+	rules, err := loadVOMapRules(file)
+	if err != nil {
+		return err
+	}
+	v.mm.Lock()
+	v.rules = rules
+	v.mm.Unlock()
+	return nil
+}
+
+// missingIdentity returns whether raw record field k is absent, empty or
+// "Unknown".
+func missingIdentity(r map[string]interface{}, k string) bool {
+	s, _ := r[k].(string)
+	s = strings.TrimSpace(s)
+	return s == "" || strings.EqualFold(s, "Unknown")
+}
+
+// process fills in the missing (absent, empty or "Unknown") VOName,
+// ReportableVOName and GlobalUsername of records with a DN, from the first of
+// the grid-mapfile (GlobalUsername only), VOMS-mapfile, and rules that has a
+// value for it. The source of each value set is put in "<field>_source".
+// Records whose VO is still missing are counted as unmapped.
+func (v *voMap) process(rec gracc.Record, r map[string]interface{}) (bool, error) {
+	dn, _ := r["DN"].(string)
+	if dn = normalizeDN(dn); dn == "" {
+		return true, nil
+	}
+	var missing = make(map[string]bool, 3)
+	for _, k := range []string{"VOName", "ReportableVOName", "GlobalUsername"} {
+		if missingIdentity(r, k) {
+			missing[k] = true
+		}
+	}
+	if len(missing) == 0 {
+		return true, nil
+	}
+	set := func(k, value, source string) {
+		if missing[k] && value != "" {
+			r[k] = value
+			r[k+"_source"] = source
+			delete(missing, k)
+		}
+	}
+	v.mm.RLock()
+	if u, ok := v.gridMap[dn]; ok {
+		set("GlobalUsername", u, sourceGridMap)
+	}
+	if vo, ok := v.vomsMap[dn]; ok {
+		set("VOName", vo, sourceVOMSMap)
+		set("ReportableVOName", reportableVO(vo), sourceVOMSMap)
+	}
+	for i := range v.rules {
+		if len(missing) == 0 {
+			break
+		}
+		for k, value := range v.rules[i].values(dn) {
+			set(k, value, sourceRule+v.rules[i].Name)
+		}
+	}
+	v.mm.RUnlock()
+	if missing["VOName"] || missing["ReportableVOName"] {
+		probe, _ := r["ProbeName"].(string)
+		v.um.Lock()
+		v.unmapped[probe]++
+		v.um.Unlock()
+	}
+	return true, nil
+}
+
+func (v *voMap) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.UnmappedDesc
+}
+
+func (v *voMap) Collect(ch chan<- prometheus.Metric) {
+	v.um.Lock()
+	defer v.um.Unlock()
+	for probe, n := range v.unmapped {
+		ch <- prometheus.MustNewConstMetric(
+			v.UnmappedDesc,
+			prometheus.CounterValue,
+			float64(n),
+			probe,
+		)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const sciabaDN = "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=sciaba/CN=430796/CN=Andrea Sciaba"
+
+func TestVOMap(t *testing.T) {
+	dir := writeTemp(t, "gracc-vomap", map[string]string{
+		"grid-mapfile": `# grid-mapfile
+"` + sciabaDN + `" sciaba,cmsuser
+/DC=org/DC=example/CN=Jane jane
+`,
+		"voms-mapfile": `
+"` + sciabaDN + `" "/cms/Role=production/Capability=NULL"
+"` + sciabaDN + `" "/atlas"
+/DC=org/DC=example/CN=Jane osg
+`,
+		"rules.json": `[
+			{"name": "fnal", "dn": "^/DC=gov/DC=fnal/.*/CN=UID:(\\w+)$",
+			 "vo": "fermilab", "globalUsername": "$1@fnal.gov"},
+			{"dn": "/O=CERN/", "vo": "/cms/Role=NULL", "reportableVO": "CMS"}
+		]`,
+	})
+	defer os.RemoveAll(dir)
+	conf := VOMapConfig{
+		GridMapFile: filepath.Join(dir, "grid-mapfile"),
+		VOMSMapFile: filepath.Join(dir, "voms-mapfile"),
+		RulesFile:   filepath.Join(dir, "rules.json"),
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	v, err := newVOMap(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.close()
+
+	for _, tc := range []struct {
+		name   string
+		file   string
+		set    map[string]interface{} // fields set before processing; nil deletes
+		expect map[string]interface{} // expected values; nil means absent
+	}{
+		{"voms-mapfile", "gracc/test_data/JobUsageRecord06.xml",
+			map[string]interface{}{"VOName": "Unknown", "ReportableVOName": nil},
+			map[string]interface{}{"VOName": "/cms/Role=production/Capability=NULL", "VOName_source": "voms-mapfile",
+				"ReportableVOName": "cms", "ReportableVOName_source": "voms-mapfile",
+				"GlobalUsername": "cmsuser@t2.ucsd.edu", "GlobalUsername_source": nil}},
+		{"grid-mapfile", "gracc/test_data/JobUsageRecord06.xml",
+			map[string]interface{}{"DN": sciabaDN + "/CN=proxy/CN=limited proxy", "GlobalUsername": ""},
+			map[string]interface{}{"GlobalUsername": "sciaba", "GlobalUsername_source": "grid-mapfile",
+				"VOName": "/cms/Role=production/Capability=NULL", "VOName_source": nil}},
+		{"unquoted", "gracc/test_data/JobUsageRecord04.xml",
+			map[string]interface{}{"DN": "/DC=org/DC=example/CN=Jane", "VOName": nil, "ReportableVOName": "unknown"},
+			map[string]interface{}{"VOName": "osg", "ReportableVOName": "osg", "GlobalUsername": "jane"}},
+		{"rule", "gracc/test_data/JobUsageRecord04.xml",
+			map[string]interface{}{"DN": "/DC=gov/DC=fnal/O=Fermilab/OU=People/CN=UID:jdoe", "VOName": nil, "ReportableVOName": nil},
+			map[string]interface{}{"VOName": "fermilab", "VOName_source": "rule:fnal",
+				"ReportableVOName": "fermilab", "GlobalUsername": "jdoe@fnal.gov", "GlobalUsername_source": "rule:fnal"}},
+		{"unnamed rule", "gracc/test_data/JobUsageRecord04.xml",
+			map[string]interface{}{"DN": "/O=CERN/CN=someone", "VOName": ""},
+			map[string]interface{}{"VOName": "/cms/Role=NULL", "VOName_source": "rule:2",
+				"ReportableVOName": "CMS", "ReportableVOName_source": nil, "GlobalUsername": nil}},
+		{"unmapped", "gracc/test_data/JobUsageRecord04.xml",
+			map[string]interface{}{"DN": "/O=Elsewhere/CN=someone", "VOName": nil},
+			map[string]interface{}{"VOName": nil, "ReportableVOName": "CMS", "ReportableVOName_source": nil}},
+		{"no DN", "gracc/test_data/JobUsageRecord02.xml", nil,
+			map[string]interface{}{"VOName": nil, "GlobalUsername": nil}},
+	} {
+		rec, r := loadRecord(t, tc.file)
+		for k, val := range tc.set {
+			if val == nil {
+				delete(r, k)
+			} else {
+				r[k] = val
+			}
+		}
+		if keep, err := v.process(rec, r); !keep || err != nil {
+			t.Fatalf("%s: record rejected: %v", tc.name, err)
+		}
+		checkFields(t, tc.name, r, tc.expect)
+	}
+	if n := v.unmapped["slurm:ce01.brazos.tamu.edu"]; n != 1 {
+		t.Errorf("expected 1 unmapped record, got %v", v.unmapped)
+	}
+
+	// the VOMS-mapfile is reloaded when it is modified
+	if err := ioutil.WriteFile(conf.VOMSMapFile, []byte("/O=Elsewhere/CN=someone glow\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(conf.VOMSMapFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range v.files {
+		if err := w.reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := map[string]interface{}{"DN": "/O=Elsewhere/CN=someone"}
+	v.process(nil, r)
+	if r["VOName"] != "glow" || r["VOName_source"] != "voms-mapfile" {
+		t.Errorf("expected VOName glow from reloaded voms-mapfile, got %v", r)
+	}
+
+	for _, bad := range []string{
+		`"/O=Example/CN=Jane jane`,
+		`/O=Example/CN=Jane`,
+		`"/O=Example/CN=Jane" "jane`,
+	} {
+		if err := ioutil.WriteFile(conf.GridMapFile, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadGridMapFile(conf.GridMapFile); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+	for _, bad := range []string{
+		`[{"dn": "(", "vo": "cms"}]`,
+		`[{"vo": "cms"}]`,
+		`[{"dn": "/O=CERN/"}]`,
+		`{"dn": "/O=CERN/", "vo": "cms"}`,
+	} {
+		if err := ioutil.WriteFile(conf.RulesFile, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadVOMapRules(conf.RulesFile); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// watchedFile is a local file, such as a mapping table, that is loaded
+// again when it is modified.
+type watchedFile struct {
+	file string
+	// load loads the file, returning an error if it is invalid.
+	load    func(file string) error
+	m       sync.Mutex
+	modTime time.Time
+	done    chan struct{}
+}
+
+// reload loads the file, if it has been modified since it was last loaded.
+func (w *watchedFile) reload() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	fi, err := os.Stat(w.file)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(w.modTime) {
+		return nil
+	}
+	if err := w.load(w.file); err != nil {
+		return err
+	}
+	w.modTime = fi.ModTime()
+	log.WithField("file", w.file).Info("loaded file")
+	return nil
+}
+
+// watch starts reloading the file when it is modified, checking every
+// interval, until close is called. If it can't be loaded the error is logged,
+// and the previous contents should be kept.
+func (w *watchedFile) watch(interval time.Duration) {
+	done := make(chan struct{})
+	w.done = done
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := w.reload(); err != nil {
+					log.WithFields(log.Fields{
+						"file":  w.file,
+						"error": err,
+					}).Error("error reloading file")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// close stops watching the file.
+func (w *watchedFile) close() {
+	if w.done != nil {
+		close(w.done)
+		w.done = nil
+	}
+}
+
+// parseInterval parses the interval between checks for changes to a watched
+// file, from config value s (default "1m").
+func parseInterval(section string, s *string, d *time.Duration) error {
+	if *s == "" {
+		*s = "1m"
+	}
+	var err error
+	if *d, err = time.ParseDuration(*s); err != nil {
+		return fmt.Errorf("error parsing %s Interval: %s", section, err)
+	}
+	if *d <= 0 {
+		return fmt.Errorf("%s Interval must be positive", section)
+	}
+	return nil
+}